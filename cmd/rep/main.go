@@ -25,6 +25,7 @@ import (
 	"code.cloudfoundry.org/executor"
 	"code.cloudfoundry.org/go-loggregator/v9/runtimeemitter"
 	k8sexecutor "code.cloudfoundry.org/k8s-garden-client/pkg/executor"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden"
 	"code.cloudfoundry.org/lager/v3"
 	"code.cloudfoundry.org/lager/v3/lagerflags"
 	"code.cloudfoundry.org/localip"
@@ -69,6 +70,11 @@ func main() {
 		panic(err.Error())
 	}
 
+	k8sConfig, err := k8sgarden.NewConfig(*configFilePath)
+	if err != nil {
+		panic(err.Error())
+	}
+
 	if *zoneOverride != "" {
 		repConfig.Zone = *zoneOverride
 	}
@@ -133,7 +139,7 @@ func main() {
 	preloadedRootFSesWithVersions := rep.StackPathMap(preloadedRootFSes).StackVersionList()
 	extraRootFSesWithVersions := extraRootFSes.StackVersionList()
 
-	executorClient, containerMetricsProvider, executorMembers, err := k8sexecutor.Initialize(logger, repConfig, repConfig.CellID, repConfig.Zone, rootFSMap, sidecarRootFSPath, metronClient, clock)
+	executorClient, containerMetricsProvider, executorMembers, err := k8sexecutor.Initialize(logger, repConfig, k8sConfig, repConfig.CellID, repConfig.Zone, rootFSMap, sidecarRootFSPath, metronClient, clock)
 	if err != nil {
 		logger.Error("failed-to-initialize-executor", err)
 		os.Exit(1)
@@ -51,6 +51,7 @@ import (
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
@@ -76,18 +77,6 @@ const (
 	megabytesToBytes               = 1024 * 1024
 )
 
-type executorContainers struct {
-	gardenClient garden.Client
-	owner        string
-}
-
-func (containers *executorContainers) Containers() ([]garden.Container, error) {
-	return containers.gardenClient.Containers(garden.Properties{
-		executor.ContainerOwnerProperty: containers.owner,
-		executor.ContainerStateProperty: "all",
-	})
-}
-
 //go:generate counterfeiter -o fakes/fake_cert_pool_retriever.go . CertPoolRetriever
 type CertPoolRetriever interface {
 	SystemCerts() (*x509.CertPool, error)
@@ -189,6 +178,7 @@ var (
 func Initialize(
 	logger lager.Logger,
 	config config.RepConfig,
+	k8sConfig k8sgarden.Config,
 	cellID string,
 	zone string,
 	rootFSes map[string]string,
@@ -246,11 +236,42 @@ func Initialize(
 	if err != nil {
 		return nil, nil, grouper.Members{}, err
 	}
-	gardenClient, err := k8sgarden.NewClient(logger.Session("k8sgarden"), mgr.GetClient(), containerd.NewClientWrapper(containerdClient), kubeletClient, cmdrunner, rundmc.NewNstarRunner("/bin/nstar", "/bin/tar", cmdrunner), users.LookupFunc(users.LookupUser), config, sidecarRootFSPath, workloadsNamespace)
+	podInformer, err := mgr.GetCache().GetInformer(context.Background(), &corev1.Pod{})
+	if err != nil {
+		return nil, nil, grouper.Members{}, err
+	}
+
+	registryCAs, err := systemcertsRetriever{}.SystemCerts()
 	if err != nil {
 		return nil, nil, grouper.Members{}, err
 	}
-	gardenClientFactory := k8sgarden.NewFactory(gardenClient)
+	if config.PathToCACertsForDownloads != "" {
+		registryCAs, err = appendCACerts(registryCAs, config.PathToCACertsForDownloads)
+		if err != nil {
+			return nil, nil, grouper.Members{}, err
+		}
+	}
+	registryCredentials := k8sgarden.NewRegistryCredentials(logger.Session("registry-credentials"), mgr.GetClient(), clock, k8sConfig.Timeouts(), workloadsNamespace, k8sConfig.ImagePullSecrets)
+	registries := containerd.RegistryConfig{
+		HostsDir:    k8sConfig.RegistryConfigPath,
+		RootCAs:     registryCAs,
+		Credentials: registryCredentials.Lookup,
+	}
+
+	// the background work of the garden client stops with the executor
+	gardenCtx, stopGardenClient := context.WithCancel(context.Background())
+	gardenClient, err := k8sgarden.NewClient(gardenCtx, logger.Session("k8sgarden"), mgr.GetClient(), podInformer, containerd.NewClientWrapper(containerdClient, registries), kubeletClient, cmdrunner, rundmc.NewNstarRunner("/bin/nstar", "/bin/tar", cmdrunner), users.LookupFunc(users.LookupUser), metronClient, clock, config, k8sConfig, sidecarRootFSPath, workloadsNamespace)
+	if err != nil {
+		return nil, nil, grouper.Members{}, err
+	}
+
+	podController, err := k8sgarden.NewPodController(gardenClient)
+	if err != nil {
+		return nil, nil, grouper.Members{}, err
+	}
+	if err := podController.SetupWithManager(mgr); err != nil {
+		return nil, nil, grouper.Members{}, err
+	}
 	// END GARDEN.CLIENT INSTANTIATION FOR KUBERNETES
 
 	err = waitForGarden(logger, gardenClient, metronClient, clock)
@@ -258,10 +279,14 @@ func Initialize(
 		return nil, nil, nil, err
 	}
 
-	containersFetcher := &executorContainers{
-		gardenClient: gardenClient,
-		owner:        config.ContainerOwnerName,
+	// containers of the previous run are taken over instead of destroyed, so
+	// that restarting the rep does not stop the app instances on the node
+	restoredContainers, err := k8sgarden.RestoreContainers(logger, gardenClient, config.ContainerOwnerName)
+	if err != nil {
+		logger.Error("failed-to-restore-containers", err)
+		return nil, nil, nil, err
 	}
+	gardenClientFactory := k8sgarden.NewFactory(gardenClient, restoredContainers)
 
 	creationWorkPool, err = workpool.NewWorkPool(config.CreateWorkPoolSize)
 	if err != nil {
@@ -280,11 +305,6 @@ func Initialize(
 		return nil, nil, nil, err
 	}
 
-	err = destroyContainers(gardenClient, containersFetcher, logger)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
 	healthCheckWorkPool, err := workpool.NewWorkPool(config.HealthCheckWorkPoolSize)
 	if err != nil {
 		return nil, nil, grouper.Members{}, err
@@ -401,7 +421,7 @@ func Initialize(
 
 	logManager := containerstore.NewLogManager()
 
-	containerStore := containerstore.New(
+	containerStore := k8sgarden.NewContainerStore(containerstore.New(
 		containerConfig,
 		&totalCapacity,
 		gardenClientFactory,
@@ -422,7 +442,7 @@ func Initialize(
 		config.AdvertisePreferenceForInstanceAddress,
 		volumeMountedFilesHandler,
 		json.Marshal,
-	)
+	), gardenClient, hub, restoredContainers)
 
 	depotClient := depot.NewClient(
 		totalCapacity,
@@ -483,6 +503,7 @@ func Initialize(
 				Tags:           map[string]string{"zone": config.Zone},
 			}},
 			{Name: "hub-closer", Runner: closeHub(logger, hub)},
+			{Name: "garden-client-stopper", Runner: stopOnSignal(logger.Session("stop-garden-client"), stopGardenClient)},
 			{Name: "container-metrics-reporter", Runner: reportersRunner},
 			// {Name: "garden_health_checker", Runner: gardenhealth.NewRunner(
 			// 	time.Duration(config.GardenHealthcheckInterval),
@@ -496,6 +517,7 @@ func Initialize(
 			// )},
 			{Name: "registry-pruner", Runner: containerStore.NewRegistryPruner(logger)},
 			{Name: "container-reaper", Runner: containerStore.NewContainerReaper(logger)},
+			{Name: "container-monitor", Runner: k8sgarden.NewContainerMonitor(logger, gardenClient, hub, restoredContainers, clock, time.Duration(config.ContainerReapInterval))},
 		},
 		nil
 }
@@ -562,48 +584,6 @@ func fetchCapacity(logger lager.Logger, gardenClient GardenClient.Client, config
 	return capacity, nil
 }
 
-func destroyContainers(gardenClient garden.Client, containersFetcher *executorContainers, logger lager.Logger) error {
-	logger.Info("executor-fetching-containers-to-destroy")
-	containers, err := containersFetcher.Containers()
-	if err != nil {
-		logger.Error("executor-failed-to-get-containers", err)
-		return err
-	}
-
-	logger.Info("executor-fetched-containers-to-destroy", lager.Data{"num-containers": len(containers)})
-
-	type containerDeletionResult struct {
-		handle string
-		err    error
-	}
-
-	errInfoChannel := make(chan containerDeletionResult, len(containers))
-	for _, container := range containers {
-		go func(c garden.Container) {
-			deletionWorkPool.Submit(func() {
-				err := gardenClient.Destroy(c.Handle())
-				errInfoChannel <- containerDeletionResult{handle: c.Handle(), err: err}
-			})
-		}(container)
-	}
-
-	for range containers {
-		result := <-errInfoChannel
-		if result.err != nil {
-			logger.Error("executor-failed-to-destroy-container", result.err, lager.Data{
-				"handle": result.handle,
-			})
-			return result.err
-		} else {
-			logger.Info("executor-destroyed-stray-container", lager.Data{
-				"handle": result.handle,
-			})
-		}
-	}
-
-	return nil
-}
-
 func setupWorkDir(logger lager.Logger, tempDir string) string {
 	workDir := filepath.Join(tempDir, "executor-work")
 
@@ -695,6 +675,16 @@ func closeHub(logger lager.Logger, hub event.Hub) ifrit.Runner {
 	})
 }
 
+func stopOnSignal(logger lager.Logger, stop context.CancelFunc) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		close(ready)
+		signal := <-signals
+		stop()
+		logger.Info("signalled", lager.Data{"signal": signal.String()})
+		return nil
+	})
+}
+
 func TLSConfigFromConfig(logger lager.Logger, certsRetriever CertPoolRetriever, config initializer.ExecutorConfig) (*tls.Config, error) {
 	var tlsConfig *tls.Config
 	var err error
@@ -880,8 +870,18 @@ func newControllerManager(logger lager.Logger, workloadsNamespace string) (manag
 		return nil, err
 	}
 
+	nodeSelector := fields.SelectorFromSet(fields.Set{"spec.nodeName": os.Getenv("NODE_NAME")})
+
 	mgr, err := manager.New(ctrlconfig.GetConfigOrDie(), manager.Options{
 		Scheme: clientgoscheme.Scheme,
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				// state secrets are only read on startup and not worth a watch,
+				// network policies are updated right after they were created and
+				// must not be read from a stale cache
+				DisableFor: []client.Object{&corev1.Secret{}, &networkingv1.NetworkPolicy{}},
+			},
+		},
 		Controller: cconfig.Controller{
 			NeedLeaderElection: ptr.To(false),
 		},
@@ -891,9 +891,14 @@ func newControllerManager(logger lager.Logger, workloadsNamespace string) (manag
 				&corev1.Pod{}: {
 					Namespaces: map[string]cache.Config{
 						workloadsNamespace: {
-							FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": os.Getenv("NODE_NAME")}),
+							FieldSelector: nodeSelector,
 							LabelSelector: labels.NewSelector().Add(*podSelector),
 						},
+						// the host ports of all pods on the node are taken into
+						// account when allocating ports
+						cache.AllNamespaces: {
+							FieldSelector: nodeSelector,
+						},
 					},
 				},
 			},
@@ -903,6 +908,13 @@ func newControllerManager(logger lager.Logger, workloadsNamespace string) (manag
 		return nil, err
 	}
 
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, "spec.nodeName", func(obj client.Object) []string {
+		return []string{obj.(*corev1.Pod).Spec.NodeName}
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	_, err = mgr.GetCache().GetInformerForKind(context.Background(), corev1.SchemeGroupVersion.WithKind("Pod"), cache.BlockUntilSynced(true))
 	if err != nil {
 		return nil, err
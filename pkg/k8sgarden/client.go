@@ -4,14 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"math/rand/v2"
 	"os"
+	"strconv"
 	"strings"
-	"time"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/commandrunner"
+	loggingclient "code.cloudfoundry.org/diego-logging-client"
 	"code.cloudfoundry.org/executor"
 	"code.cloudfoundry.org/garden"
+	"code.cloudfoundry.org/guardian/gardener"
 	"code.cloudfoundry.org/guardian/properties"
 	"code.cloudfoundry.org/guardian/rundmc"
 	"code.cloudfoundry.org/guardian/rundmc/users"
@@ -19,10 +23,12 @@ import (
 	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/kubelet"
 	"code.cloudfoundry.org/lager/v3"
 	"code.cloudfoundry.org/rep/cmd/rep/config"
+	ctrdclient "github.com/containerd/containerd/v2/client"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -32,11 +38,10 @@ const (
 	SpaceGUIDLabel    = "cloudfoundry.org/space-guid"
 	WorkloadTypeKey   = "cloudfoundry.org/workload-type"
 	OwnerNameLabelKey = "cloudfoundry.org/owner-name"
+	HandleLabelKey    = "cloudfoundry.org/container-handle"
 
 	appContainerName     = "app"
 	sidecarContainerName = "sidecar"
-
-	apiOperationTimeout = 10 * time.Second
 )
 
 var alphanum = []rune("abcdefghijklmnopqrstuvwxyz1234567890")
@@ -51,12 +56,15 @@ func randSeq(n int) string {
 
 type client struct {
 	k8sclient            ctrlclient.Client
+	deadlines            deadlines
+	podInformer          cache.Informer
 	kubeletClient        kubelet.Client
 	containerdClient     containerd.Client
 	logger               lager.Logger
 	node                 *corev1.Node
 	containers           *containerMap
 	portManager          PortManager
+	portForwarder        PortForwarder
 	cmdRunner            commandrunner.CommandRunner
 	nstarRunner          rundmc.NstarRunner
 	userLookupper        users.UserLookupper
@@ -66,28 +74,47 @@ type client struct {
 	nodeMemoryInB        int64
 	sidecarRootfs        string
 	enableContainerProxy bool
+	bandwidthLimits      bool
 	workloadsNamespace   string
+	imagePullSecrets     []corev1.LocalObjectReference
+	deletions            *deletionReconciler
+	images               *imageCollector
+	imagePull            ImagePull
 }
 
 var _ garden.Client = &client{}
 
-func NewClient(logger lager.Logger, k8sclient ctrlclient.Client, containerdClient containerd.Client, kubeletClient kubelet.Client, cmdRunner commandrunner.CommandRunner, nstarRunner rundmc.NstarRunner, userLookupper users.UserLookupper, repConfig config.RepConfig, sidecarRootfs, workloadsNamespace string) (garden.Client, error) {
+// NewClient returns a [garden.Client] running the containers as pods on the
+// node, taking over the pods left from a previous run. The removal of unused
+// images and the cleanup after destroyed containers run in the background
+// until ctx is done.
+func NewClient(ctx context.Context, logger lager.Logger, k8sclient ctrlclient.Client, podInformer cache.Informer, containerdClient containerd.Client, kubeletClient kubelet.Client, cmdRunner commandrunner.CommandRunner, nstarRunner rundmc.NstarRunner, userLookupper users.UserLookupper, metronClient loggingclient.IngressClient, clk clock.Clock, repConfig config.RepConfig, k8sConfig Config, sidecarRootfs, workloadsNamespace string) (garden.Client, error) {
+	deadlines := newDeadlines(clk, k8sConfig.Timeouts())
+
+	nodeCtx, cancel := deadlines.apiContext()
+	defer cancel()
+
 	node := &corev1.Node{}
-	if err := k8sclient.Get(context.Background(), ctrlclient.ObjectKey{Name: os.Getenv("NODE_NAME")}, node); err != nil {
+	if err := k8sclient.Get(nodeCtx, ctrlclient.ObjectKey{Name: os.Getenv("NODE_NAME")}, node); err != nil {
 		return nil, fmt.Errorf("failed to get node %s: %w", os.Getenv("NODE_NAME"), err)
 	}
 
 	nodeCPU, _ := node.Status.Capacity.Cpu().AsInt64()
 	nodeMemoryBytes, _ := node.Status.Capacity.Memory().AsInt64()
 
-	containerMap, propertyManager, err := containerRestoreInfo(k8sclient, workloadsNamespace)
+	startPort, endPort := k8sConfig.HostPortRange()
+	portManager := NewPortManager(startPort, endPort, nodeHostPorts(k8sclient, deadlines, node.Name), metronClient)
+	portForwarder := NewPortForwarder(cmdRunner)
+	containerMap, propertyManager, err := containerRestoreInfo(logger, k8sclient, deadlines, containerdClient, kubeletClient, nstarRunner, userLookupper, portManager, portForwarder, workloadsNamespace)
 	if err != nil {
 		return nil, err
 	}
 
-	return &client{
+	c := &client{
 		kubeletClient:        kubeletClient,
 		k8sclient:            k8sclient,
+		deadlines:            deadlines,
+		podInformer:          podInformer,
 		containerdClient:     containerdClient,
 		logger:               logger,
 		node:                 node,
@@ -96,14 +123,37 @@ func NewClient(logger lager.Logger, k8sclient ctrlclient.Client, containerdClien
 		sidecarRootfs:        sidecarRootfs,
 		trustedCertsDir:      repConfig.TrustedSystemCertificatesPath,
 		enableContainerProxy: repConfig.EnableContainerProxy,
+		bandwidthLimits:      !k8sConfig.DisableBandwidthLimits,
 		cmdRunner:            cmdRunner,
 		nstarRunner:          nstarRunner,
 		userLookupper:        userLookupper,
 		containers:           containerMap,
-		portManager:          newPortManager(),
+		portManager:          portManager,
+		portForwarder:        portForwarder,
 		propertyManager:      propertyManager,
 		workloadsNamespace:   workloadsNamespace,
-	}, nil
+		imagePull:            k8sConfig.ImagePull(),
+	}
+
+	for _, name := range k8sConfig.ImagePullSecrets {
+		c.imagePullSecrets = append(c.imagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+
+	c.images = newImageCollector(logger.Session("image-collector"), containerdClient, deadlines, k8sConfig.ImageGC())
+	for _, cntr := range containerMap.List() {
+		if img, ok := podImage(cntr.(*container).currentPod()); ok {
+			c.images.acquire(cntr.Handle(), img)
+		}
+	}
+	go c.images.run(ctx)
+
+	c.deletions = newDeletionReconciler(c)
+	if _, err := podInformer.AddEventHandler(c.deletions.podEventHandler()); err != nil {
+		return nil, fmt.Errorf("failed to watch pods: %w", err)
+	}
+	go c.deletions.run(ctx)
+
+	return c, nil
 }
 
 func (c *client) BulkMetrics(handles []string) (map[string]garden.ContainerMetricsEntry, error) {
@@ -122,30 +172,9 @@ func (c *client) BulkMetrics(handles []string) (map[string]garden.ContainerMetri
 			return nil, fmt.Errorf("failed to get container %s for metrics: %w", handle, err)
 		}
 
-		metricEntry := garden.ContainerMetricsEntry{
-			Metrics: garden.Metrics{
-				MemoryStat: garden.ContainerMemoryStat{
-					TotalUsageTowardLimit: metric.MemoryUsageInBytes,
-				},
-				CPUStat: garden.ContainerCPUStat{
-					Usage: uint64(metric.TimeSpentInCPU),
-				},
-				DiskStat: garden.ContainerDiskStat{
-					TotalBytesUsed: metric.DiskUsageInBytes + uint64(ctr.rootfsSize),
-				},
-				Age:            time.Duration(metric.ContainerAgeInNanoseconds),
-				CPUEntitlement: uint64(ctr.cpuAssignment * float64(metric.ContainerAgeInNanoseconds)),
-			},
-		}
-
-		if metric.RxInBytes != nil && metric.TxInBytes != nil {
-			metricEntry.Metrics.NetworkStat = &garden.ContainerNetworkStat{
-				RxBytes: *metric.RxInBytes,
-				TxBytes: *metric.TxInBytes,
-			}
+		metricsMap[handle] = garden.ContainerMetricsEntry{
+			Metrics: ctr.gardenMetrics(metric),
 		}
-
-		metricsMap[handle] = metricEntry
 	}
 
 	return metricsMap, nil
@@ -180,6 +209,13 @@ func (c *client) Containers(properties garden.Properties) ([]garden.Container, e
 
 	var matchedContainers []garden.Container
 	for _, cntr := range containers {
+		// destroyed containers are gone for the caller; the ones whose pods
+		// terminated stay until they are destroyed, their processes exited
+		// and their info is stopped, which the executor reports as a crash
+		if cntr.(*container).isDestroying() {
+			continue
+		}
+
 		matched := c.propertyManager.MatchesAll(cntr.Handle(), properties)
 		if matched {
 			matchedContainers = append(matchedContainers, cntr)
@@ -189,7 +225,11 @@ func (c *client) Containers(properties garden.Properties) ([]garden.Container, e
 	return matchedContainers, nil
 }
 
-func (c *client) Create(spec garden.ContainerSpec) (garden.Container, error) {
+// Create implements [garden.Client].
+//
+// Every side effect is undone in reverse order if the container can not be
+// created, so that creating it can be retried with the same handle.
+func (c *client) Create(spec garden.ContainerSpec) (_ garden.Container, err error) {
 	c.logger.Info("create-container-start", lager.Data{"spec": spec})
 	defer c.logger.Info("create-container-end")
 
@@ -197,17 +237,39 @@ func (c *client) Create(spec garden.ContainerSpec) (garden.Container, error) {
 		return nil, fmt.Errorf("Handle '%s' already in use", spec.Handle)
 	}
 
+	// the grace time is recorded like SetGraceTime does, without changing the
+	// properties of the caller
+	if spec.GraceTime != 0 {
+		spec.Properties = maps.Clone(spec.Properties)
+		if spec.Properties == nil {
+			spec.Properties = garden.Properties{}
+		}
+		spec.Properties[gardener.GraceTimeKey] = fmt.Sprintf("%d", spec.GraceTime)
+	}
+
+	undo := newRollback(c.logger.Session("create-rollback", lager.Data{"handle": spec.Handle}))
+	defer func() {
+		if err != nil {
+			undo.run()
+		}
+	}()
+
 	cpuAssignment := cpuQuantity(float64(spec.Limits.Memory.LimitInBytes)/(1024.0*1024.0), c.nodeCPU, c.nodeMemoryInB)
 	ports := make([]corev1.ContainerPort, 0, len(spec.NetIn))
 	for idx, netin := range spec.NetIn {
 		hostPort := netin.HostPort
 		if hostPort == 0 {
-			var err error
 			hostPort, err = c.portManager.Next()
 			if err != nil {
 				return nil, fmt.Errorf("failed to allocate host port: %w", err)
 			}
 			spec.NetIn[idx].HostPort = hostPort
+
+			allocated := hostPort
+			undo.add("release-host-port", func() error {
+				c.portManager.Release(allocated)
+				return nil
+			})
 		}
 
 		ports = append(ports, corev1.ContainerPort{
@@ -221,18 +283,21 @@ func (c *client) Create(spec garden.ContainerSpec) (garden.Container, error) {
 	var (
 		dockerEnv  []string
 		rootfsSize uint64
-		err        error
+		pulled     *pulledImage
 	)
 	if cutImg, ok := strings.CutPrefix(baseImage, "docker:"); ok {
-		img, imgSize, err := c.containerdClient.Pull(context.Background(), strings.TrimLeft(strings.ReplaceAll(cutImg, "#", ":"), "/"), spec.Image.Username, spec.Image.Password)
+		img, imgSize, err := c.pullImage(spec.Handle, strings.TrimLeft(strings.ReplaceAll(cutImg, "#", ":"), "/"), spec.Image.Username, spec.Image.Password)
 		if err != nil {
-			return nil, fmt.Errorf("failed to pull docker image: %w", err)
+			return nil, err
 		}
+		pulled = &pulledImage{name: img.Name(), digest: img.Target().Digest}
+		undo.add("release-image", func() error {
+			return c.images.discard(spec.Handle)
+		})
 		rootfsSize = uint64(imgSize)
 
 		if rootfsSize >= spec.Limits.Disk.ByteHard {
-			err = c.containerdClient.Delete(context.Background(), img)
-			return nil, errors.Join(fmt.Errorf("image size %d exceeds container disk limit %d", rootfsSize, spec.Limits.Disk.ByteHard), err)
+			return nil, fmt.Errorf("image size %d exceeds container disk limit %d", rootfsSize, spec.Limits.Disk.ByteHard)
 		}
 
 		baseImage = img.Name()
@@ -243,19 +308,40 @@ func (c *client) Create(spec garden.ContainerSpec) (garden.Container, error) {
 		dockerEnv = imgSpec.Config.Env
 	}
 
+	annotations, err := stateAnnotations(spec.Properties, cpuAssignment)
+	if err != nil {
+		return nil, err
+	}
+	annotations[RootfsSizeAnnotationKey] = strconv.FormatUint(rootfsSize, 10)
+	if pulled != nil {
+		annotations[ImageDigestAnnotationKey] = pulled.digest.String()
+	}
+	if spec.Limits.Bandwidth.RateInBytesPerSecond > 0 {
+		if c.bandwidthLimits {
+			maps.Copy(annotations, bandwidthAnnotations(spec.Limits.Bandwidth))
+		} else {
+			c.logger.Info("ignoring-bandwidth-limits", lager.Data{"handle": spec.Handle, "limits": spec.Limits.Bandwidth})
+		}
+	}
+
+	labels := podLabels(spec.Properties)
+	labels[HandleLabelKey] = spec.Handle
+
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      spec.Handle,
-			Namespace: c.workloadsNamespace,
-			Labels:    podLabels(spec.Properties),
+			Name:        spec.Handle,
+			Namespace:   c.workloadsNamespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: corev1.PodSpec{
 			AutomountServiceAccountToken:  ptr.To(false),
 			EnableServiceLinks:            ptr.To(false),
 			NodeName:                      c.node.GetName(),
-			TerminationGracePeriodSeconds: ptr.To(int64(5)),
+			TerminationGracePeriodSeconds: ptr.To(terminationGracePeriodSeconds(spec.GraceTime)),
 			HostUsers:                     ptr.To(true), // should work with "false" too, but fails in KinD
 			RestartPolicy:                 corev1.RestartPolicyNever,
+			ImagePullSecrets:              c.imagePullSecrets,
 			Resources: &corev1.ResourceRequirements{
 				Requests: corev1.ResourceList{
 					corev1.ResourceCPU: resource.MustParse(fmt.Sprintf("%dm", int(cpuAssignment*1000))),
@@ -363,50 +449,62 @@ func (c *client) Create(spec garden.ContainerSpec) (garden.Container, error) {
 	for key, value := range spec.Properties {
 		c.propertyManager.Set(pod.GetName(), key, value)
 	}
+	undo.add("remove-properties", func() error {
+		return c.propertyManager.DestroyKeySpace(spec.Handle)
+	})
+	env := append(dockerEnv, spec.Env...)
 	container := NewContainer(
 		c.logger.Session(fmt.Sprintf("container-%s", spec.Handle)),
 		pod,
-		append(dockerEnv, spec.Env...),
+		env,
 		cpuAssignment,
 		c.nstarRunner,
 		c.userLookupper,
 		c.propertyManager,
 		rootfsSize,
 		nil,
+		c.containerdClient,
+		c.kubeletClient,
+		c.k8sclient,
+		c.deadlines.clock,
+		c.deadlines.timeouts,
+		c.portManager,
+		c.portForwarder,
 	)
 	if err := c.containers.Add(spec.Handle, container); err != nil {
 		return nil, err
 	}
+	undo.add("remove-container", func() error {
+		c.containers.Remove(spec.Handle)
+		return nil
+	})
+
+	// the policy has to be in place before the pod starts, egress is denied
+	// by default like in garden and only allowed by the rules of the spec
+	undo.add("delete-network-policy", func() error {
+		return deleteNetOutPolicy(c.k8sclient, c.deadlines, spec.Handle, c.workloadsNamespace)
+	})
+	if err := applyNetOutRules(c.logger, c.k8sclient, c.deadlines, spec.Handle, c.workloadsNamespace, spec.NetOut); err != nil {
+		return nil, err
+	}
 
-	if err := c.k8sclient.Create(context.Background(), pod); err != nil {
-		return nil, fmt.Errorf("failed to create pod: %w", err)
+	// the environment can hold credentials and is only recorded in the state secret
+	undo.add("delete-state-secret", func() error {
+		return deleteStateSecret(c.k8sclient, c.deadlines, spec.Handle, c.workloadsNamespace)
+	})
+	if err := applyStateSecret(c.k8sclient, c.deadlines, spec.Handle, c.workloadsNamespace, env, spec.Properties); err != nil {
+		return nil, err
 	}
 
-	// wait until the pod is running
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	ctx, cancel := c.deadlines.podStartupContext()
 	defer cancel()
-	for {
-		if err := c.k8sclient.Get(ctx, ctrlclient.ObjectKeyFromObject(pod), pod); ctrlclient.IgnoreNotFound(err) != nil {
-			return nil, fmt.Errorf("failed to get pod after creation: %w", err)
-		}
-
-		if pod.Status.Phase == corev1.PodRunning {
-			break
-		} else {
-			c.logger.Info("waiting-for-pod-to-be-running", lager.Data{"pod-name": spec.Handle, "current-phase": pod.Status.Phase})
-		}
-
-		select {
-		case <-ctx.Done():
-			_ = c.k8sclient.Delete(context.Background(), pod, &ctrlclient.DeleteOptions{
-				GracePeriodSeconds: ptr.To[int64](0),
-			})
-			return nil, fmt.Errorf("timed out waiting for pod to be running")
-		case <-time.After(500 * time.Millisecond):
-		}
+	running, err := createPodAndWait(ctx, c.logger.Session("create-pod", lager.Data{"handle": spec.Handle}), c.k8sclient, c.deadlines, c.podInformer, pod, undo)
+	if err != nil {
+		return nil, err
 	}
+	container.setPod(running)
 
-	container.taskMap, err = c.containerdClient.LoadTasks(context.Background(), pod.Status.ContainerStatuses)
+	container.taskMap, err = c.containerdClient.LoadTasks(context.Background(), running.Status.ContainerStatuses)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load containerD container: %w", err)
 	}
@@ -418,24 +516,23 @@ func (c *client) Create(spec garden.ContainerSpec) (garden.Container, error) {
 	return container, nil
 }
 
+// Destroy implements [garden.Client].
+//
+// The container is marked as destroying and its pod is deleted in the
+// background with the grace time of the container. The container is cleaned
+// up, and its host ports are released, once the pod is gone.
 func (c *client) Destroy(handle string) error {
 	container, err := c.containers.Get(handle)
 	if err != nil {
 		return err
 	}
 
-	if err := deletePod(c.logger, container.pod, c.k8sclient); err != nil {
-		return fmt.Errorf("failed to delete pod: %w", err)
-	}
-
-	for _, container := range container.pod.Spec.Containers {
-		for _, port := range container.Ports {
-			c.portManager.Release(uint32(port.HostPort))
-		}
+	if container.markDestroying() {
+		c.logger.Info("destroying-container", lager.Data{"handle": handle})
 	}
-	c.containers.Remove(handle)
+	c.deletions.enqueue()
 
-	return c.propertyManager.DestroyKeySpace(handle)
+	return nil
 }
 
 func (c *client) Lookup(handle string) (garden.Container, error) {
@@ -454,15 +551,57 @@ func (c *client) Ping() error {
 	return nil
 }
 
+// FreeHostPorts returns the number of host ports left for containers.
+func (c *client) FreeHostPorts() (int, error) {
+	return c.portManager.Free()
+}
+
 func (c *client) BulkInfo(handles []string) (map[string]garden.ContainerInfoEntry, error) {
-	panic("unimplemented")
+	log := c.logger.Session("bulk-info")
+
+	log.Info("starting", lager.Data{"handles": len(handles)})
+	defer log.Info("finished")
+
+	infos := make(map[string]garden.ContainerInfoEntry, len(handles))
+	for _, handle := range handles {
+		ctr, err := c.containers.Get(handle)
+		if err != nil {
+			infos[handle] = garden.ContainerInfoEntry{Err: garden.NewError(err.Error())}
+			continue
+		}
+
+		pod, err := c.getPod(ctr.currentPod())
+		if err != nil {
+			log.Error("failed-to-get-pod", err, lager.Data{"handle": handle})
+			infos[handle] = garden.ContainerInfoEntry{Err: garden.NewError(fmt.Sprintf("failed to get pod for container %s: %s", handle, err))}
+			continue
+		}
+
+		infos[handle] = garden.ContainerInfoEntry{Info: ctr.infoFromPod(pod)}
+	}
+
+	return infos, nil
+}
+
+func (c *client) getPod(pod *corev1.Pod) (*corev1.Pod, error) {
+	ctx, cancel := c.deadlines.apiContext()
+	defer cancel()
+
+	current := &corev1.Pod{}
+	if err := c.k8sclient.Get(ctx, ctrlclient.ObjectKeyFromObject(pod), current); err != nil {
+		return nil, err
+	}
+
+	return current, nil
 }
 
-func deletePod(logger lager.Logger, pod *corev1.Pod, clnt ctrlclient.Client) error {
-	ctx, cancel := context.WithTimeout(context.Background(), apiOperationTimeout)
+// deletePod deletes the pod and waits until it is gone, asking the API server
+// every poll interval.
+func deletePod(logger lager.Logger, pod *corev1.Pod, clnt ctrlclient.Client, d deadlines, opts ...ctrlclient.DeleteOption) error {
+	ctx, cancel := d.apiContext()
 	defer cancel()
 
-	if err := clnt.Delete(ctx, pod); err != nil {
+	if err := clnt.Delete(ctx, pod, opts...); err != nil {
 		if ctrlclient.IgnoreNotFound(err) == nil {
 			return nil
 		}
@@ -483,10 +622,8 @@ func deletePod(logger lager.Logger, pod *corev1.Pod, clnt ctrlclient.Client) err
 		}
 
 		logger.Info("waiting-for-pod-deletion", lager.Data{"pod-name": pod.Name})
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(500 * time.Millisecond):
+		if err := d.poll(ctx); err != nil {
+			return err
 		}
 	}
 }
@@ -531,33 +668,109 @@ func podLabels(properties garden.Properties) map[string]string {
 	return labels
 }
 
-func containerRestoreInfo(client ctrlclient.Client, workloadsNamespace string) (*containerMap, *properties.Manager, error) {
+// podProperties returns the garden properties that are recorded in the labels
+// of the pod, the reverse of podLabels.
+func podProperties(pod *corev1.Pod) garden.Properties {
+	properties := garden.Properties{}
+
+	for property, label := range map[string]string{
+		"network.app_id":                AppGUIDLabelKey,
+		"network.org_id":                OrgGUIDLabelKey,
+		"network.space_id":              SpaceGUIDLabel,
+		"network.container_workload":    WorkloadTypeKey,
+		executor.ContainerOwnerProperty: OwnerNameLabelKey,
+	} {
+		if value, ok := pod.Labels[label]; ok {
+			properties[property] = value
+		}
+	}
+
+	// pods that never started were not handed out by Create
+	if pod.Status.Phase != corev1.PodPending {
+		properties["garden.state"] = "created"
+	}
+
+	return properties
+}
+
+// containerRestoreInfo rebuilds the containers from the pods left from a
+// previous run, so that they survive a restart of the rep. Their properties
+// and environment are read back from the pods and their state secrets, the
+// containerd tasks of running pods are loaded again, processes started before
+// the restart keep running and can be attached to.
+func containerRestoreInfo(logger lager.Logger, client ctrlclient.Client, deadlines deadlines, containerdClient containerd.Client, kubeletClient kubelet.Client, nstarRunner rundmc.NstarRunner, userLookupper users.UserLookupper, portManager PortManager, portForwarder PortForwarder, workloadsNamespace string) (*containerMap, *properties.Manager, error) {
+	log := logger.Session("restore-containers")
+
+	ctx, cancel := deadlines.apiContext()
+	defer cancel()
+
 	podList := &corev1.PodList{}
-	if err := client.List(context.Background(), podList, ctrlclient.InNamespace(workloadsNamespace)); err != nil {
+	if err := client.List(ctx, podList, ctrlclient.InNamespace(workloadsNamespace)); err != nil {
 		return nil, nil, fmt.Errorf("failed to list existing pods: %w", err)
 	}
 
 	containerMap := newContainerMap()
 	propertyManager := properties.NewManager()
 
+	pods := make([]*corev1.Pod, 0, len(podList.Items))
 	for _, pod := range podList.Items {
-		propertyManager.Set(pod.Name, executor.ContainerOwnerProperty, pod.Labels[OwnerNameLabelKey])
+		pods = append(pods, &pod)
+
+		state, err := restorePodState(client, deadlines, &pod)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for key, value := range state.properties {
+			propertyManager.Set(pod.Name, key, value)
+		}
+
+		var taskMap map[string]ctrdclient.Task
+		if pod.Status.Phase == corev1.PodRunning {
+			taskMap, err = containerdClient.LoadTasks(context.Background(), pod.Status.ContainerStatuses)
+			if err != nil {
+				// the container can still be destroyed without its tasks
+				log.Error("failed-to-load-tasks", err, lager.Data{"handle": pod.Name})
+			}
+		}
 
 		container := NewContainer(
-			nil,
+			logger.Session(fmt.Sprintf("container-%s", pod.Name)),
 			&pod,
-			[]string{},
-			0,
-			nil,
-			nil,
+			state.env,
+			state.cpuAssignment,
+			nstarRunner,
+			userLookupper,
 			propertyManager,
-			0,
-			nil,
+			state.rootfsSize,
+			taskMap,
+			containerdClient,
+			kubeletClient,
+			client,
+			deadlines.clock,
+			deadlines.timeouts,
+			portManager,
+			portForwarder,
 		)
-		err := containerMap.Add(pod.Name, container)
+		// the deletion of the pod is finished by the deletion reconciler
+		if pod.DeletionTimestamp != nil {
+			container.markDestroying()
+		}
+
+		err = containerMap.Add(pod.Name, container)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to add container to map: %w", err)
 		}
+
+		log.Info("restored-container", lager.Data{"handle": pod.Name, "phase": pod.Status.Phase})
+	}
+
+	if err := restorePorts(portManager, pods); err != nil {
+		return nil, nil, err
+	}
+
+	if err := restoreForwards(portForwarder, pods); err != nil {
+		return nil, nil, err
 	}
 
 	return containerMap, propertyManager, nil
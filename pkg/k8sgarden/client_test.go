@@ -3,14 +3,28 @@ package k8sgarden_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
-
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
 	"code.cloudfoundry.org/commandrunner/fake_command_runner"
+	. "code.cloudfoundry.org/commandrunner/fake_command_runner/matchers"
+	mfakes "code.cloudfoundry.org/diego-logging-client/testhelpers"
+	"code.cloudfoundry.org/durationjson"
 	"code.cloudfoundry.org/executor"
 	"code.cloudfoundry.org/executor/initializer"
 	"code.cloudfoundry.org/garden"
 	"code.cloudfoundry.org/guardian/rundmc/rundmcfakes"
+	"code.cloudfoundry.org/guardian/rundmc/users"
 	"code.cloudfoundry.org/guardian/rundmc/users/usersfakes"
 	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden"
 	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/containerd/containerdfakes"
@@ -18,17 +32,29 @@ import (
 	"code.cloudfoundry.org/lager/v3/lagertest"
 	"code.cloudfoundry.org/rep/cmd/rep/config"
 	ctrdclient "github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	remoteerrors "github.com/containerd/containerd/v2/core/remotes/errors"
+	"github.com/containerd/errdefs"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	. "github.com/onsi/gomega/gstruct"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 var _ = Describe("Client", func() {
@@ -40,29 +66,65 @@ var _ = Describe("Client", func() {
 		fakeCmdRunner        *fake_command_runner.FakeCommandRunner
 		fakeNstarRunner      *rundmcfakes.FakeNstarRunner
 		fakeUserLookupper    *usersfakes.FakeUserLookupper
+		fakeMetronClient     *mfakes.FakeIngressClient
+		fakePodInformer      *controllertest.FakeInformer
+		fakeClock            *fakeclock.FakeClock
 		repConfig            config.RepConfig
+		k8sConfig            k8sgarden.Config
 		sidecarRootfs        string
 		testNode             *corev1.Node
 		scheme               *runtime.Scheme
 		gardenClient         garden.Client
+		clientCtx            context.Context
+		stopClient           context.CancelFunc
 		err                  error
 		tempDir              string
 
 		workloadsNamespace = "cf-workloads"
 
 		failPodCreation bool
+		podStartup      func(pod *corev1.Pod)
+		failRequests    map[string]bool
+		blockRequests   map[string]bool
+
+		blockedRequests         atomic.Int32
+		failPodDeletions        atomic.Bool
+		failSecretDeletions     atomic.Bool
+		podDeletionGracePeriods func() []int64
 	)
 
 	BeforeEach(func() {
+		clientCtx, stopClient = context.WithCancel(context.Background())
+		DeferCleanup(stopClient)
+
 		logger = lagertest.NewTestLogger("k8sgarden-test")
 		fakeContainerdClient = &containerdfakes.FakeClient{}
 		fakeKubeletClient = &kubeletfakes.FakeClient{}
 		fakeCmdRunner = fake_command_runner.New()
 		fakeNstarRunner = &rundmcfakes.FakeNstarRunner{}
 		fakeUserLookupper = &usersfakes.FakeUserLookupper{}
+		fakeMetronClient = &mfakes.FakeIngressClient{}
+		fakePodInformer = controllertest.NewFakeInformer(controllertest.Synced)
+		fakeClock = fakeclock.NewFakeClock(time.Now())
 		sidecarRootfs = "sidecar-rootfs"
 
 		failPodCreation = false
+		podStartup = nil
+		failRequests = map[string]bool{}
+		blockRequests = map[string]bool{}
+		blockedRequests.Store(0)
+
+		failPodDeletions.Store(false)
+		failSecretDeletions.Store(false)
+		var (
+			gracePeriods   []int64
+			gracePeriodsMu sync.Mutex
+		)
+		podDeletionGracePeriods = func() []int64 {
+			gracePeriodsMu.Lock()
+			defer gracePeriodsMu.Unlock()
+			return slices.Clone(gracePeriods)
+		}
 
 		tempDir = GinkgoT().TempDir()
 
@@ -72,6 +134,7 @@ var _ = Describe("Client", func() {
 				TrustedSystemCertificatesPath: filepath.Join(tempDir, "trusted-certs"),
 			},
 		}
+		k8sConfig = k8sgarden.Config{}
 		repConfig.InstanceIdentityCredDir = filepath.Join(tempDir, "instance-identity")
 		repConfig.ContainerProxyConfigPath = filepath.Join(tempDir, "container-proxy")
 		repConfig.VolumeMountedFiles = filepath.Join(tempDir, "volume-mounted-files")
@@ -108,13 +171,31 @@ var _ = Describe("Client", func() {
 			WithScheme(scheme).
 			WithInterceptorFuncs(interceptor.Funcs{
 				Create: func(ctx context.Context, c ctrlclient.WithWatch, obj ctrlclient.Object, opts ...ctrlclient.CreateOption) error {
+					if failRequests["create/"+reflect.TypeOf(obj).Elem().Name()] {
+						return errors.New("simulated create failure")
+					}
+					if blockRequests["create/"+reflect.TypeOf(obj).Elem().Name()] {
+						blockedRequests.Add(1)
+						<-ctx.Done()
+						return ctx.Err()
+					}
+
 					if pod, ok := obj.(*corev1.Pod); ok {
+						if podStartup != nil {
+							if err := c.Create(context.Background(), obj); err != nil {
+								return err
+							}
+							podStartup(pod.DeepCopy())
+							return nil
+						}
+
 						if pod.Status.Phase != corev1.PodRunning {
 							if failPodCreation {
 								return errors.New("simulated pod creation failure")
 							}
 
 							pod.Status.Phase = corev1.PodRunning
+							pod.Status.PodIP = "10.244.0.10"
 							pod.Status.ContainerStatuses = []corev1.ContainerStatus{
 								{Name: "app", ContainerID: "containerd://test", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
 							}
@@ -123,19 +204,55 @@ var _ = Describe("Client", func() {
 
 					return c.Create(context.Background(), obj)
 				},
+				Delete: func(ctx context.Context, c ctrlclient.WithWatch, obj ctrlclient.Object, opts ...ctrlclient.DeleteOption) error {
+					if _, ok := obj.(*corev1.Secret); ok && failSecretDeletions.Load() {
+						return errors.New("simulated secret deletion failure")
+					}
+
+					if _, ok := obj.(*corev1.Pod); ok {
+						if failPodDeletions.Load() {
+							return errors.New("simulated pod deletion failure")
+						}
+
+						deleteOptions := &ctrlclient.DeleteOptions{}
+						deleteOptions.ApplyOptions(opts)
+						if deleteOptions.GracePeriodSeconds != nil {
+							gracePeriodsMu.Lock()
+							gracePeriods = append(gracePeriods, *deleteOptions.GracePeriodSeconds)
+							gracePeriodsMu.Unlock()
+						}
+					}
+
+					return c.Delete(ctx, obj, opts...)
+				},
+				Patch: func(ctx context.Context, c ctrlclient.WithWatch, obj ctrlclient.Object, patch ctrlclient.Patch, opts ...ctrlclient.PatchOption) error {
+					if failRequests["patch/"+reflect.TypeOf(obj).Elem().Name()] {
+						return errors.New("simulated patch failure")
+					}
+
+					return c.Patch(ctx, obj, patch, opts...)
+				},
 			}).
 			WithObjects(testNode).
+			WithIndex(&corev1.Pod{}, "spec.nodeName", func(obj ctrlclient.Object) []string {
+				return []string{obj.(*corev1.Pod).Spec.NodeName}
+			}).
 			Build()
 
 		gardenClient, err = k8sgarden.NewClient(
+			clientCtx,
 			logger,
 			k8sClient,
+			fakePodInformer,
 			fakeContainerdClient,
 			fakeKubeletClient,
 			fakeCmdRunner,
 			fakeNstarRunner,
 			fakeUserLookupper,
+			fakeMetronClient,
+			fakeClock,
 			repConfig,
+			k8sConfig,
 			sidecarRootfs,
 			workloadsNamespace,
 		)
@@ -153,14 +270,19 @@ var _ = Describe("Client", func() {
 		Context("when all dependencies are healthy", func() {
 			It("creates a new garden client successfully", func() {
 				gardenClient, err = k8sgarden.NewClient(
+					clientCtx,
 					logger,
 					k8sClient,
+					fakePodInformer,
 					fakeContainerdClient,
 					fakeKubeletClient,
 					fakeCmdRunner,
 					fakeNstarRunner,
 					fakeUserLookupper,
+					fakeMetronClient,
+					fakeClock,
 					repConfig,
+					k8sConfig,
 					sidecarRootfs,
 					workloadsNamespace,
 				)
@@ -177,14 +299,19 @@ var _ = Describe("Client", func() {
 
 			It("fails to create client", func() {
 				gardenClient, err = k8sgarden.NewClient(
+					clientCtx,
 					logger,
 					k8sClient,
+					fakePodInformer,
 					fakeContainerdClient,
 					fakeKubeletClient,
 					fakeCmdRunner,
 					fakeNstarRunner,
 					fakeUserLookupper,
+					fakeMetronClient,
+					fakeClock,
 					repConfig,
+					k8sConfig,
 					sidecarRootfs,
 					workloadsNamespace,
 				)
@@ -222,14 +349,19 @@ var _ = Describe("Client", func() {
 					Build()
 
 				gardenClient, err = k8sgarden.NewClient(
+					clientCtx,
 					logger,
 					k8sClient,
+					fakePodInformer,
 					fakeContainerdClient,
 					fakeKubeletClient,
 					fakeCmdRunner,
 					fakeNstarRunner,
 					fakeUserLookupper,
+					fakeMetronClient,
+					fakeClock,
 					repConfig,
+					k8sConfig,
 					sidecarRootfs,
 					workloadsNamespace,
 				)
@@ -295,14 +427,19 @@ var _ = Describe("Client", func() {
 				Expect(k8sClient.Create(context.Background(), orphanedPod)).To(Succeed())
 
 				gardenClient, err = k8sgarden.NewClient(
+					clientCtx,
 					logger,
 					k8sClient,
+					fakePodInformer,
 					fakeContainerdClient,
 					fakeKubeletClient,
 					fakeCmdRunner,
 					fakeNstarRunner,
 					fakeUserLookupper,
+					fakeMetronClient,
+					fakeClock,
 					repConfig,
+					k8sConfig,
 					sidecarRootfs,
 					workloadsNamespace,
 				)
@@ -319,6 +456,174 @@ var _ = Describe("Client", func() {
 				Expect(containers[0].Handle()).To(Equal("orphaned-pod-1"))
 				Expect(containers[0].Properties()).To(HaveKeyWithValue(executor.ContainerOwnerProperty, "executor"))
 			})
+
+			It("finishes destroying the containers of terminating pods on startup", func() {
+				terminatingPod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "terminating-pod",
+						Namespace:         workloadsNamespace,
+						Finalizers:        []string{"test/finalizer"},
+						DeletionTimestamp: ptr.To(metav1.Now()),
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{Name: "app", Image: "test-image", Ports: []corev1.ContainerPort{{ContainerPort: 8080, HostPort: 62000}}},
+						},
+					},
+				}
+				k8sClient = fake.NewClientBuilder().
+					WithScheme(scheme).
+					WithObjects(testNode, terminatingPod).
+					WithIndex(&corev1.Pod{}, "spec.nodeName", func(obj ctrlclient.Object) []string {
+						return []string{obj.(*corev1.Pod).Spec.NodeName}
+					}).
+					Build()
+
+				gardenClient, err = k8sgarden.NewClient(
+					clientCtx,
+					logger,
+					k8sClient,
+					fakePodInformer,
+					fakeContainerdClient,
+					fakeKubeletClient,
+					fakeCmdRunner,
+					fakeNstarRunner,
+					fakeUserLookupper,
+					fakeMetronClient,
+					fakeClock,
+					repConfig,
+					k8sConfig,
+					sidecarRootfs,
+					workloadsNamespace,
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(gardenClient.Containers(garden.Properties{executor.ContainerStateProperty: "all"})).To(BeEmpty())
+				Expect(gardenClient.Lookup("terminating-pod")).To(HaveField("Handle()", "terminating-pod"))
+
+				Eventually(fakeClock.WatcherCount).Should(Equal(1))
+				terminatingPod.Finalizers = nil
+				Expect(k8sClient.Update(context.Background(), terminatingPod)).To(Succeed())
+				fakePodInformer.Delete(terminatingPod)
+
+				Eventually(func() error {
+					_, err := gardenClient.Lookup("terminating-pod")
+					return err
+				}).Should(HaveOccurred())
+			})
+
+			It("reattaches to the containers of running pods on startup", func() {
+				runningPod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "running-pod",
+						Namespace: "cf-workloads",
+						Labels: map[string]string{
+							k8sgarden.OwnerNameLabelKey: "executor",
+							k8sgarden.AppGUIDLabelKey:   "app-guid",
+						},
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{Name: "app", Image: "test-image"},
+						},
+					},
+				}
+				Expect(k8sClient.Create(context.Background(), runningPod)).To(Succeed())
+
+				fakeProcess := &containerdfakes.FakeProcess{}
+				fakeProcess.WaitReturns(make(chan ctrdclient.ExitStatus), nil)
+				fakeTask := &containerdfakes.FakeTask{}
+				fakeTask.ExecReturns(fakeProcess, nil)
+				fakeContainerdClient.LoadTasksReturns(map[string]ctrdclient.Task{"app": fakeTask}, nil)
+				fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 1000, Home: "/home/vcap"}, nil)
+
+				gardenClient, err = k8sgarden.NewClient(
+					clientCtx,
+					logger,
+					k8sClient,
+					fakePodInformer,
+					fakeContainerdClient,
+					fakeKubeletClient,
+					fakeCmdRunner,
+					fakeNstarRunner,
+					fakeUserLookupper,
+					fakeMetronClient,
+					fakeClock,
+					repConfig,
+					k8sConfig,
+					sidecarRootfs,
+					workloadsNamespace,
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeContainerdClient.LoadTasksCallCount()).To(Equal(1))
+				_, statuses := fakeContainerdClient.LoadTasksArgsForCall(0)
+				Expect(statuses).To(ConsistOf(HaveField("ContainerID", "containerd://test")))
+
+				containers, err := gardenClient.Containers(nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(containers).To(HaveLen(1))
+				Expect(containers[0].Properties()).To(And(
+					HaveKeyWithValue("network.app_id", "app-guid"),
+					HaveKeyWithValue(executor.ContainerOwnerProperty, "executor"),
+				))
+
+				_, err = containers[0].Run(garden.ProcessSpec{ID: "app", Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeTask.ExecCallCount()).To(Equal(1))
+			})
+
+			It("restores the port forwarding of the containers on startup", func() {
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "pod-with-net-in",
+						Namespace: "cf-workloads",
+						Annotations: map[string]string{
+							k8sgarden.NetInAnnotationKey: `[{"HostPort":62005,"ContainerPort":8080}]`,
+						},
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{Name: "app", Image: "test-image"},
+						},
+					},
+				}
+				Expect(k8sClient.Create(context.Background(), pod)).To(Succeed())
+
+				gardenClient, err = k8sgarden.NewClient(
+					clientCtx,
+					logger,
+					k8sClient,
+					fakePodInformer,
+					fakeContainerdClient,
+					fakeKubeletClient,
+					fakeCmdRunner,
+					fakeNstarRunner,
+					fakeUserLookupper,
+					fakeMetronClient,
+					fakeClock,
+					repConfig,
+					k8sConfig,
+					sidecarRootfs,
+					workloadsNamespace,
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeCmdRunner).To(HaveExecutedSerially(
+					fake_command_runner.CommandSpec{
+						Path: "nsenter",
+						Args: []string{"--net=/proc/1/ns/net", "--", "iptables", "-w", "-t", "nat", "-F", "K8S-GARDEN-NETIN"},
+					},
+					fake_command_runner.CommandSpec{
+						Path: "nsenter",
+						Args: []string{
+							"--net=/proc/1/ns/net", "--", "iptables", "-w", "-t", "nat", "-A", "K8S-GARDEN-NETIN",
+							"-p", "tcp", "--dport", "62005", "-m", "comment", "--comment", "pod-with-net-in",
+							"-j", "DNAT", "--to-destination", "10.244.0.10:8080",
+						},
+					},
+				))
+			})
 		})
 	})
 
@@ -372,6 +677,221 @@ var _ = Describe("Client", func() {
 		})
 	})
 
+	Describe("BulkInfo", func() {
+		BeforeEach(func() {
+			spec := garden.ContainerSpec{
+				Handle: "info-container",
+				NetIn: []garden.NetIn{
+					{HostPort: 0, ContainerPort: 8080},
+				},
+				Image: garden.ImageRef{
+					URI: "cflinuxfs4",
+				},
+				Limits: garden.Limits{
+					Disk: garden.DiskLimits{
+						ByteHard: 1024 * 1024 * 1024,
+					},
+				},
+			}
+			_, err := gardenClient.Create(spec)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the info of every requested container from the pod cache", func() {
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "info-container", Namespace: workloadsNamespace}, pod)).To(Succeed())
+			pod.Status.HostIP = "10.0.0.1"
+			pod.Status.PodIP = "10.244.0.5"
+			Expect(k8sClient.Status().Update(context.Background(), pod)).To(Succeed())
+
+			infos, err := gardenClient.BulkInfo([]string{"info-container"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(infos).To(HaveKey("info-container"))
+			Expect(infos["info-container"].Err).To(BeNil())
+			Expect(infos["info-container"].Info.State).To(Equal("active"))
+			Expect(infos["info-container"].Info.HostIP).To(Equal("10.0.0.1"))
+			Expect(infos["info-container"].Info.ContainerIP).To(Equal("10.244.0.5"))
+			Expect(infos["info-container"].Info.MappedPorts).To(ConsistOf(garden.PortMapping{HostPort: 62000, ContainerPort: 8080}))
+		})
+
+		It("reports unknown handles per entry without failing the batch", func() {
+			infos, err := gardenClient.BulkInfo([]string{"info-container", "unknown-container"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(infos).To(HaveLen(2))
+			Expect(infos["info-container"].Err).To(BeNil())
+			Expect(infos["unknown-container"].Err).To(MatchError(ContainSubstring("unknown-container")))
+		})
+
+		It("reports an error for containers whose pod is gone", func() {
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "info-container", Namespace: workloadsNamespace}, pod)).To(Succeed())
+			Expect(k8sClient.Delete(context.Background(), pod)).To(Succeed())
+
+			infos, err := gardenClient.BulkInfo([]string{"info-container"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(infos["info-container"].Err).To(MatchError(ContainSubstring("failed to get pod for container info-container")))
+		})
+	})
+
+	Describe("PodController", func() {
+		var (
+			podController *k8sgarden.PodController
+			request       reconcile.Request
+		)
+
+		BeforeEach(func() {
+			_, err := gardenClient.Create(garden.ContainerSpec{
+				Handle:     "watched-container",
+				Properties: garden.Properties{executor.ContainerOwnerProperty: "executor"},
+				Limits:     garden.Limits{Disk: garden.DiskLimits{ByteHard: 1024 * 1024 * 1024}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			podController, err = k8sgarden.NewPodController(gardenClient)
+			Expect(err).NotTo(HaveOccurred())
+			request = reconcile.Request{NamespacedName: types.NamespacedName{Name: "watched-container", Namespace: workloadsNamespace}}
+		})
+
+		updatePodStatus := func(update func(status *corev1.PodStatus)) {
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(context.Background(), request.NamespacedName, pod)).To(Succeed())
+			update(&pod.Status)
+			Expect(k8sClient.Status().Update(context.Background(), pod)).To(Succeed())
+		}
+
+		It("keeps the status of the pod of the container current", func() {
+			updatePodStatus(func(status *corev1.PodStatus) {
+				status.PodIP = "10.244.0.42"
+			})
+
+			Expect(podController.Reconcile(context.Background(), request)).To(Equal(reconcile.Result{}))
+
+			container, err := gardenClient.Lookup("watched-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(container.Info()).To(HaveField("ContainerIP", "10.244.0.42"))
+		})
+
+		It("marks the container as terminated when its pod was deleted by someone else", func() {
+			Expect(k8sClient.Delete(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "watched-container", Namespace: workloadsNamespace}})).To(Succeed())
+
+			Expect(podController.Reconcile(context.Background(), request)).To(Equal(reconcile.Result{}))
+
+			container, err := gardenClient.Lookup("watched-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(container.Property(k8sgarden.TerminationReasonProperty)).To(Equal("PodDeleted: pod was deleted"))
+			Expect(container.Info()).To(HaveField("State", "stopped"))
+		})
+
+		It("keeps terminated containers listed until they are destroyed", func() {
+			updatePodStatus(func(status *corev1.PodStatus) {
+				status.Phase = corev1.PodFailed
+				status.Reason = "Evicted"
+				status.Message = "The node was low on resource: memory."
+			})
+
+			Expect(podController.Reconcile(context.Background(), request)).To(Equal(reconcile.Result{}))
+
+			containers, err := gardenClient.Containers(garden.Properties{executor.ContainerOwnerProperty: "executor"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containers).To(ConsistOf(HaveField("Handle()", "watched-container")))
+
+			Expect(gardenClient.Destroy("watched-container")).To(Succeed())
+			Expect(gardenClient.Containers(garden.Properties{executor.ContainerOwnerProperty: "executor"})).To(BeEmpty())
+		})
+
+		It("records the reason of pods that were evicted", func() {
+			updatePodStatus(func(status *corev1.PodStatus) {
+				status.Phase = corev1.PodFailed
+				status.Reason = "Evicted"
+				status.Message = "The node was low on resource: memory."
+			})
+
+			Expect(podController.Reconcile(context.Background(), request)).To(Equal(reconcile.Result{}))
+
+			container, err := gardenClient.Lookup("watched-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(container.Property(k8sgarden.TerminationReasonProperty)).To(Equal("Evicted: The node was low on resource: memory."))
+
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(context.Background(), request.NamespacedName, pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(k8sgarden.PropertiesAnnotationKey, ContainSubstring("Evicted: The node was low on resource: memory.")))
+		})
+
+		It("records the reason of pods that were evicted for their ephemeral storage", func() {
+			updatePodStatus(func(status *corev1.PodStatus) {
+				status.Phase = corev1.PodFailed
+				status.Reason = "Evicted"
+				status.Message = "Pod ephemeral local storage usage exceeds the total limit of containers 1G."
+			})
+
+			Expect(podController.Reconcile(context.Background(), request)).To(Equal(reconcile.Result{}))
+
+			container, err := gardenClient.Lookup("watched-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(container.Property(k8sgarden.TerminationReasonProperty)).To(Equal("Evicted: Pod ephemeral local storage usage exceeds the total limit of containers 1G."))
+			Expect(container.Info()).To(HaveField("Events", ConsistOf(k8sgarden.DiskLimitExceededEvent)))
+		})
+
+		It("retries recording the termination reason on the pod", func() {
+			updatePodStatus(func(status *corev1.PodStatus) {
+				status.Phase = corev1.PodFailed
+				status.Reason = "Evicted"
+				status.Message = "The node was low on resource: memory."
+			})
+
+			failRequests["patch/Pod"] = true
+			_, err := podController.Reconcile(context.Background(), request)
+			Expect(err).To(MatchError(ContainSubstring("failed to record termination reason of watched-container")))
+
+			failRequests["patch/Pod"] = false
+			Expect(podController.Reconcile(context.Background(), request)).To(Equal(reconcile.Result{}))
+
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(context.Background(), request.NamespacedName, pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(k8sgarden.PropertiesAnnotationKey, ContainSubstring("Evicted")))
+		})
+
+		It("records the reason of app containers that exited", func() {
+			updatePodStatus(func(status *corev1.PodStatus) {
+				status.ContainerStatuses = []corev1.ContainerStatus{
+					{Name: "app", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}}},
+				}
+			})
+
+			Expect(podController.Reconcile(context.Background(), request)).To(Equal(reconcile.Result{}))
+
+			container, err := gardenClient.Lookup("watched-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(container.Property(k8sgarden.TerminationReasonProperty)).To(Equal("OOMKilled: container app exited with code 137: "))
+		})
+
+		It("leaves the pods of destroyed containers to Destroy", func() {
+			Expect(gardenClient.Destroy("watched-container")).To(Succeed())
+
+			Expect(podController.Reconcile(context.Background(), request)).To(Equal(reconcile.Result{}))
+			Eventually(func() error {
+				_, err := gardenClient.Lookup("watched-container")
+				return err
+			}).Should(MatchError(garden.ContainerNotFoundError{Handle: "watched-container"}))
+		})
+
+		It("ignores pods that are not containers of the client", func() {
+			for _, name := range []types.NamespacedName{
+				{Name: "unknown-pod", Namespace: workloadsNamespace},
+				{Name: "watched-container", Namespace: "kube-system"},
+			} {
+				Expect(podController.Reconcile(context.Background(), reconcile.Request{NamespacedName: name})).To(Equal(reconcile.Result{}))
+			}
+
+			Expect(gardenClient.Containers(nil)).To(HaveLen(1))
+		})
+
+		It("can not be created for other garden clients", func() {
+			_, err := k8sgarden.NewPodController(nil)
+			Expect(err).To(MatchError(ContainSubstring("does not run containers in pods")))
+		})
+	})
+
 	Describe("Create", func() {
 		var (
 			fakeTask *containerdfakes.FakeTask
@@ -464,6 +984,38 @@ var _ = Describe("Client", func() {
 			Expect(containers).To(HaveLen(1))
 		})
 
+		It("attaches the configured image pull secrets to the pod", func() {
+			k8sConfig.ImagePullSecrets = []string{"artifactory", "docker-hub"}
+			gardenClient, err = k8sgarden.NewClient(
+				clientCtx,
+				logger,
+				k8sClient,
+				fakePodInformer,
+				fakeContainerdClient,
+				fakeKubeletClient,
+				fakeCmdRunner,
+				fakeNstarRunner,
+				fakeUserLookupper,
+				fakeMetronClient,
+				fakeClock,
+				repConfig,
+				k8sConfig,
+				sidecarRootfs,
+				workloadsNamespace,
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err := gardenClient.Create(garden.ContainerSpec{
+				Handle: "test-container-pull-secrets",
+				Limits: garden.Limits{Disk: garden.DiskLimits{ByteHard: 1024 * 1024 * 1024}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var pod corev1.Pod
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "test-container-pull-secrets", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+			Expect(pod.Spec.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{{Name: "artifactory"}, {Name: "docker-hub"}}))
+		})
+
 		It("creates a docker app container successfully", func() {
 			fakeContainerdClient.PullReturns(&containerdfakes.FakeImage{
 				NameStub: func() string {
@@ -501,21 +1053,21 @@ var _ = Describe("Client", func() {
 			Expect(pod.Spec.Containers[0].Resources.Limits.StorageEphemeral().Value()).To(Equal(int64((1024 * 1024 * 1024) - 9999)))
 		})
 
-		It("returns an error if the image is larger than the disk limit", func() {
+		It("reports the same limits for restored containers", func() {
 			fakeContainerdClient.PullReturns(&containerdfakes.FakeImage{
 				NameStub: func() string {
 					return "docker.io/library/busybox:latest"
 				},
-			}, 20, nil)
+			}, 9999, nil)
 
 			spec := garden.ContainerSpec{
-				Handle: "test-container-2",
+				Handle: "test-container-limits",
 				Limits: garden.Limits{
 					Memory: garden.MemoryLimits{
 						LimitInBytes: 256 * 1024 * 1024,
 					},
 					Disk: garden.DiskLimits{
-						ByteHard: 10,
+						ByteHard: 1024 * 1024 * 1024,
 					},
 				},
 				Image: garden.ImageRef{
@@ -524,13 +1076,52 @@ var _ = Describe("Client", func() {
 			}
 
 			container, err := gardenClient.Create(spec)
-			Expect(err).To(MatchError("image size 20 exceeds container disk limit 10"))
-			Expect(container).To(BeNil())
+			Expect(err).NotTo(HaveOccurred())
+
+			memoryLimits, err := container.CurrentMemoryLimits()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(memoryLimits.LimitInBytes).To(Equal(uint64(256 * 1024 * 1024)))
+			cpuLimits, err := container.CurrentCPULimits()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cpuLimits.Weight).NotTo(BeZero())
+			diskLimits, err := container.CurrentDiskLimits()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diskLimits.ByteHard).To(Equal(uint64(1024 * 1024 * 1024)))
+
+			restoredClient, err := k8sgarden.NewClient(
+				clientCtx,
+				logger,
+				k8sClient,
+				fakePodInformer,
+				fakeContainerdClient,
+				fakeKubeletClient,
+				fakeCmdRunner,
+				fakeNstarRunner,
+				fakeUserLookupper,
+				fakeMetronClient,
+				fakeClock,
+				repConfig,
+				k8sConfig,
+				sidecarRootfs,
+				workloadsNamespace,
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			restored, err := restoredClient.Lookup("test-container-limits")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored.CurrentMemoryLimits()).To(Equal(memoryLimits))
+			Expect(restored.CurrentCPULimits()).To(Equal(cpuLimits))
+			Expect(restored.CurrentDiskLimits()).To(Equal(diskLimits))
 		})
 
-		It("returns error when creating a container with an existing name", func() {
+		It("restores the properties and the environment of restored containers", func() {
 			spec := garden.ContainerSpec{
-				Handle: "test-container-2",
+				Handle: "test-container-state",
+				Properties: garden.Properties{
+					executor.ContainerOwnerProperty: "executor",
+					"network.app_id":                "test-app-guid",
+				},
+				Env: []string{"SECRET_ENV=secret-value"},
 				Limits: garden.Limits{
 					Memory: garden.MemoryLimits{
 						LimitInBytes: 256 * 1024 * 1024,
@@ -544,18 +1135,493 @@ var _ = Describe("Client", func() {
 				},
 			}
 
-			_, err := gardenClient.Create(spec)
+			container, err := gardenClient.Create(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(container.SetGraceTime(30 * time.Second)).To(Succeed())
+			Expect(container.SetProperty("large-property", strings.Repeat("x", 64*1024))).To(Succeed())
+			Expect(container.SetProperty(k8sgarden.ExecutorContainerProperty, `{"run_info":{"env":["PASSWORD=secret"]}}`)).To(Succeed())
+			properties, err := container.Properties()
 			Expect(err).NotTo(HaveOccurred())
 
-			_, err = gardenClient.Create(spec)
-			Expect(err).To(MatchError("Handle 'test-container-2' already in use"))
-		})
+			var pod corev1.Pod
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "test-container-state", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(k8sgarden.PropertiesAnnotationKey, HavePrefix("gzip:")))
+			for _, value := range pod.Annotations {
+				Expect(value).NotTo(ContainSubstring("secret"))
+			}
 
-		It("returns error when pod creation fails", func() {
-			spec := garden.ContainerSpec{
-				Handle: "test-container-3",
-				Limits: garden.Limits{
-					Memory: garden.MemoryLimits{
+			restoredClient, err := k8sgarden.NewClient(
+				clientCtx,
+				logger,
+				k8sClient,
+				fakePodInformer,
+				fakeContainerdClient,
+				fakeKubeletClient,
+				fakeCmdRunner,
+				fakeNstarRunner,
+				fakeUserLookupper,
+				fakeMetronClient,
+				fakeClock,
+				repConfig,
+				k8sConfig,
+				sidecarRootfs,
+				workloadsNamespace,
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			restored, err := restoredClient.Lookup("test-container-state")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored.Properties()).To(Equal(properties))
+
+			fakeProcess := &containerdfakes.FakeProcess{}
+			fakeProcess.WaitReturns(make(chan ctrdclient.ExitStatus), nil)
+			fakeTask.ExecReturns(fakeProcess, nil)
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 1000, Home: "/home/vcap"}, nil)
+
+			_, err = restored.Run(garden.ProcessSpec{Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+			_, _, processSpec, _ := fakeTask.ExecArgsForCall(0)
+			Expect(processSpec.Env).To(ContainElement("SECRET_ENV=secret-value"))
+		})
+
+		Context("when host ports are already in use", func() {
+			var spec garden.ContainerSpec
+
+			BeforeEach(func() {
+				spec = garden.ContainerSpec{
+					Handle: "test-container-ports",
+					Limits: garden.Limits{
+						Memory: garden.MemoryLimits{
+							LimitInBytes: 256 * 1024 * 1024,
+						},
+						Disk: garden.DiskLimits{
+							ByteHard: 1024 * 1024 * 1024,
+						},
+					},
+					Image: garden.ImageRef{
+						URI: "cflinuxfs4",
+					},
+					NetIn: []garden.NetIn{
+						{ContainerPort: 8080},
+					},
+				}
+			})
+
+			hostPortOf := func(handle string) int32 {
+				var pod corev1.Pod
+				Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: handle, Namespace: workloadsNamespace}, &pod)).To(Succeed())
+				return pod.Spec.Containers[0].Ports[0].HostPort
+			}
+
+			It("does not hand out the host ports of restored pods", func() {
+				restoredPod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "restored-pod",
+						Namespace: workloadsNamespace,
+						Annotations: map[string]string{
+							k8sgarden.NetInAnnotationKey: `[{"HostPort":62001,"ContainerPort":2222}]`,
+						},
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{
+								Name:  "app",
+								Image: "test-image",
+								Ports: []corev1.ContainerPort{{HostPort: 62000, ContainerPort: 8080}},
+							},
+						},
+					},
+				}
+				Expect(k8sClient.Create(context.Background(), restoredPod)).To(Succeed())
+
+				gardenClient, err = k8sgarden.NewClient(
+					clientCtx,
+					logger,
+					k8sClient,
+					fakePodInformer,
+					fakeContainerdClient,
+					fakeKubeletClient,
+					fakeCmdRunner,
+					fakeNstarRunner,
+					fakeUserLookupper,
+					fakeMetronClient,
+					fakeClock,
+					repConfig,
+					k8sConfig,
+					sidecarRootfs,
+					workloadsNamespace,
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = gardenClient.Create(spec)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hostPortOf("test-container-ports")).To(Equal(int32(62002)))
+			})
+
+			Context("when the host port range is exhausted", func() {
+				BeforeEach(func() {
+					k8sConfig.HostPortRangeStart = 62000
+					k8sConfig.HostPortRangeEnd = 62000
+
+					gardenClient, err = k8sgarden.NewClient(
+						clientCtx,
+						logger,
+						k8sClient,
+						fakePodInformer,
+						fakeContainerdClient,
+						fakeKubeletClient,
+						fakeCmdRunner,
+						fakeNstarRunner,
+						fakeUserLookupper,
+						fakeMetronClient,
+						fakeClock,
+						repConfig,
+						k8sConfig,
+						sidecarRootfs,
+						workloadsNamespace,
+					)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("returns a port range exhausted error", func() {
+					_, err := gardenClient.Create(spec)
+					Expect(err).NotTo(HaveOccurred())
+
+					spec.Handle = "test-container-ports-2"
+					spec.NetIn = []garden.NetIn{{ContainerPort: 8080}}
+					_, err = gardenClient.Create(spec)
+					Expect(err).To(MatchError(k8sgarden.PortRangeExhaustedError{Start: 62000, End: 62000}))
+				})
+
+				It("counts the free host ports", func() {
+					counter, ok := gardenClient.(interface{ FreeHostPorts() (int, error) })
+					Expect(ok).To(BeTrue())
+					Expect(counter.FreeHostPorts()).To(Equal(1))
+
+					_, err := gardenClient.Create(spec)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(counter.FreeHostPorts()).To(Equal(0))
+				})
+
+				It("emits the allocated and free host ports", func() {
+					_, err := gardenClient.Create(spec)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeMetronClient.SendMetricCallCount()).To(BeNumerically(">=", 2))
+					metrics := map[string]int{}
+					for i := range fakeMetronClient.SendMetricCallCount() {
+						name, value, _ := fakeMetronClient.SendMetricArgsForCall(i)
+						metrics[name] = value
+					}
+					Expect(metrics).To(Equal(map[string]int{
+						k8sgarden.HostPortsAllocatedMetric: 1,
+						k8sgarden.HostPortsFreeMetric:      0,
+					}))
+				})
+			})
+
+			It("does not hand out the host ports of other pods on the node", func() {
+				daemonSetPod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "daemon-set-pod",
+						Namespace: "kube-system",
+					},
+					Spec: corev1.PodSpec{
+						NodeName: "test-node",
+						Containers: []corev1.Container{
+							{
+								Name:  "agent",
+								Image: "agent-image",
+								Ports: []corev1.ContainerPort{{HostPort: 62000, ContainerPort: 9000}},
+							},
+						},
+					},
+				}
+				hostNetworkPod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "host-network-pod",
+						Namespace: "kube-system",
+					},
+					Spec: corev1.PodSpec{
+						NodeName:    "test-node",
+						HostNetwork: true,
+						Containers: []corev1.Container{
+							{
+								Name:  "agent",
+								Image: "agent-image",
+								Ports: []corev1.ContainerPort{{ContainerPort: 62001}},
+							},
+						},
+					},
+				}
+				otherNodePod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "other-node-pod",
+						Namespace: "kube-system",
+					},
+					Spec: corev1.PodSpec{
+						NodeName: "other-node",
+						Containers: []corev1.Container{
+							{
+								Name:  "agent",
+								Image: "agent-image",
+								Ports: []corev1.ContainerPort{{HostPort: 62002, ContainerPort: 9000}},
+							},
+						},
+					},
+				}
+				completedPod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "completed-pod",
+						Namespace: "kube-system",
+					},
+					Spec: corev1.PodSpec{
+						NodeName: "test-node",
+						Containers: []corev1.Container{
+							{
+								Name:  "job",
+								Image: "job-image",
+								Ports: []corev1.ContainerPort{{HostPort: 62002, ContainerPort: 9000}},
+							},
+						},
+					},
+				}
+				for _, pod := range []*corev1.Pod{daemonSetPod, hostNetworkPod, otherNodePod, completedPod} {
+					Expect(k8sClient.Create(context.Background(), pod)).To(Succeed())
+				}
+				completedPod.Status.Phase = corev1.PodSucceeded
+				Expect(k8sClient.Status().Update(context.Background(), completedPod)).To(Succeed())
+
+				_, err := gardenClient.Create(spec)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hostPortOf("test-container-ports")).To(Equal(int32(62002)))
+			})
+		})
+
+		It("derives the termination grace period of the pod from the grace time", func() {
+			properties := garden.Properties{executor.ContainerOwnerProperty: "executor"}
+			container, err := gardenClient.Create(garden.ContainerSpec{
+				Handle:     "test-container-grace-time",
+				GraceTime:  1500 * time.Millisecond,
+				Properties: properties,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(properties).NotTo(HaveKey("garden.grace-time"))
+			Expect(container.Property("garden.grace-time")).To(Equal(fmt.Sprint(int64(1500 * time.Millisecond))))
+
+			var pod corev1.Pod
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "test-container-grace-time", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+			Expect(pod.Spec.TerminationGracePeriodSeconds).To(HaveValue(Equal(int64(2))))
+
+			_, err = gardenClient.Create(garden.ContainerSpec{Handle: "test-container-default-grace-time"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "test-container-default-grace-time", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+			Expect(pod.Spec.TerminationGracePeriodSeconds).To(HaveValue(Equal(int64(10))))
+		})
+
+		It("returns an error if the image is larger than the disk limit", func() {
+			fakeContainerdClient.PullReturns(&containerdfakes.FakeImage{
+				NameStub: func() string {
+					return "docker.io/library/busybox:latest"
+				},
+			}, 20, nil)
+
+			spec := garden.ContainerSpec{
+				Handle: "test-container-2",
+				Limits: garden.Limits{
+					Memory: garden.MemoryLimits{
+						LimitInBytes: 256 * 1024 * 1024,
+					},
+					Disk: garden.DiskLimits{
+						ByteHard: 10,
+					},
+				},
+				Image: garden.ImageRef{
+					URI: "docker:///busybox:latest",
+				},
+			}
+
+			container, err := gardenClient.Create(spec)
+			Expect(err).To(MatchError("image size 20 exceeds container disk limit 10"))
+			Expect(container).To(BeNil())
+		})
+
+		It("creates an egress network policy for the netout rules", func() {
+			spec := garden.ContainerSpec{
+				Handle: "test-container-netout",
+				Limits: garden.Limits{
+					Memory: garden.MemoryLimits{
+						LimitInBytes: 256 * 1024 * 1024,
+					},
+					Disk: garden.DiskLimits{
+						ByteHard: 1024 * 1024 * 1024,
+					},
+				},
+				Image: garden.ImageRef{
+					URI: "cflinuxfs4",
+				},
+				NetOut: []garden.NetOutRule{
+					{
+						Protocol: garden.ProtocolTCP,
+						Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP("10.0.0.1"))},
+						Ports:    []garden.PortRange{garden.PortRangeFromPort(8080)},
+					},
+				},
+			}
+
+			_, err := gardenClient.Create(spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			var pod corev1.Pod
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{
+				Name:      "test-container-netout",
+				Namespace: "cf-workloads",
+			}, &pod)).To(Succeed())
+			Expect(pod.Labels).To(HaveKeyWithValue(k8sgarden.HandleLabelKey, "test-container-netout"))
+
+			var policy networkingv1.NetworkPolicy
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{
+				Name:      "test-container-netout",
+				Namespace: "cf-workloads",
+			}, &policy)).To(Succeed())
+			Expect(policy.Spec.PodSelector.MatchLabels).To(Equal(map[string]string{k8sgarden.HandleLabelKey: "test-container-netout"}))
+			Expect(policy.Spec.Egress).To(HaveLen(2))
+			Expect(policy.Spec.Egress[1].To[0].IPBlock.CIDR).To(Equal("10.0.0.1/32"))
+
+			Expect(gardenClient.Destroy("test-container-netout")).To(Succeed())
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), ctrlclient.ObjectKey{
+					Name:      "test-container-netout",
+					Namespace: "cf-workloads",
+				}, &networkingv1.NetworkPolicy{})
+			}).Should(Satisfy(apierrors.IsNotFound))
+		})
+
+		It("denies egress except for DNS when the spec has no netout rules", func() {
+			_, err := gardenClient.Create(garden.ContainerSpec{
+				Handle: "test-container-no-netout",
+				Image:  garden.ImageRef{URI: "cflinuxfs4"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var policy networkingv1.NetworkPolicy
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{
+				Name:      "test-container-no-netout",
+				Namespace: "cf-workloads",
+			}, &policy)).To(Succeed())
+			Expect(policy.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeEgress))
+			Expect(policy.Spec.Egress).To(HaveLen(1))
+			Expect(policy.Spec.Egress[0].To).To(BeEmpty())
+			Expect(policy.Spec.Egress[0].Ports).To(HaveLen(2))
+		})
+
+		Context("when the spec has bandwidth limits", func() {
+			var spec garden.ContainerSpec
+
+			BeforeEach(func() {
+				spec = garden.ContainerSpec{
+					Handle: "test-container-bandwidth",
+					Limits: garden.Limits{
+						Bandwidth: garden.BandwidthLimits{
+							RateInBytesPerSecond:      1000 * 1000,
+							BurstRateInBytesPerSecond: 2000 * 1000,
+						},
+						Memory: garden.MemoryLimits{
+							LimitInBytes: 256 * 1024 * 1024,
+						},
+						Disk: garden.DiskLimits{
+							ByteHard: 1024 * 1024 * 1024,
+						},
+					},
+					Image: garden.ImageRef{
+						URI: "cflinuxfs4",
+					},
+				}
+			})
+
+			It("adds the bandwidth annotations to the pod", func() {
+				container, err := gardenClient.Create(spec)
+				Expect(err).NotTo(HaveOccurred())
+
+				var pod corev1.Pod
+				Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{
+					Name:      "test-container-bandwidth",
+					Namespace: "cf-workloads",
+				}, &pod)).To(Succeed())
+				Expect(pod.Annotations).To(HaveKeyWithValue(k8sgarden.IngressBandwidthAnnotationKey, "8M"))
+				Expect(pod.Annotations).To(HaveKeyWithValue(k8sgarden.EgressBandwidthAnnotationKey, "8M"))
+
+				Expect(container.CurrentBandwidthLimits()).To(Equal(spec.Limits.Bandwidth))
+			})
+
+			Context("when bandwidth limits are disabled", func() {
+				BeforeEach(func() {
+					k8sConfig.DisableBandwidthLimits = true
+					gardenClient, err = k8sgarden.NewClient(
+						clientCtx,
+						logger,
+						k8sClient,
+						fakePodInformer,
+						fakeContainerdClient,
+						fakeKubeletClient,
+						fakeCmdRunner,
+						fakeNstarRunner,
+						fakeUserLookupper,
+						fakeMetronClient,
+						fakeClock,
+						repConfig,
+						k8sConfig,
+						sidecarRootfs,
+						workloadsNamespace,
+					)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("does not limit the bandwidth of the pod", func() {
+					container, err := gardenClient.Create(spec)
+					Expect(err).NotTo(HaveOccurred())
+
+					var pod corev1.Pod
+					Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{
+						Name:      "test-container-bandwidth",
+						Namespace: "cf-workloads",
+					}, &pod)).To(Succeed())
+					Expect(pod.Annotations).NotTo(HaveKey(k8sgarden.IngressBandwidthAnnotationKey))
+					Expect(pod.Annotations).NotTo(HaveKey(k8sgarden.EgressBandwidthAnnotationKey))
+
+					Expect(container.CurrentBandwidthLimits()).To(BeZero())
+				})
+			})
+		})
+
+		It("returns error when creating a container with an existing name", func() {
+			spec := garden.ContainerSpec{
+				Handle: "test-container-2",
+				Limits: garden.Limits{
+					Memory: garden.MemoryLimits{
+						LimitInBytes: 256 * 1024 * 1024,
+					},
+					Disk: garden.DiskLimits{
+						ByteHard: 1024 * 1024 * 1024,
+					},
+				},
+				Image: garden.ImageRef{
+					URI: "cflinuxfs4",
+				},
+			}
+
+			_, err := gardenClient.Create(spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = gardenClient.Create(spec)
+			Expect(err).To(MatchError("Handle 'test-container-2' already in use"))
+		})
+
+		It("returns error when pod creation fails", func() {
+			spec := garden.ContainerSpec{
+				Handle: "test-container-3",
+				Limits: garden.Limits{
+					Memory: garden.MemoryLimits{
 						LimitInBytes: 256 * 1024 * 1024,
 					},
 					Disk: garden.DiskLimits{
@@ -585,6 +1651,341 @@ var _ = Describe("Client", func() {
 			Expect(err.Error()).To(ContainSubstring("failed to create pod"))
 		})
 
+		Context("when the pod does not start right away", func() {
+			var spec garden.ContainerSpec
+
+			BeforeEach(func() {
+				spec = garden.ContainerSpec{
+					Handle: "test-container-startup",
+					Limits: garden.Limits{
+						Memory: garden.MemoryLimits{
+							LimitInBytes: 256 * 1024 * 1024,
+						},
+						Disk: garden.DiskLimits{
+							ByteHard: 1024 * 1024 * 1024,
+						},
+					},
+					Image: garden.ImageRef{
+						URI: "cflinuxfs4",
+					},
+				}
+			})
+
+			It("waits for the pod to be running", func() {
+				podStartup = func(pod *corev1.Pod) {
+					pending := pod.DeepCopy()
+					pending.Status.Phase = corev1.PodPending
+					fakePodInformer.Add(pending)
+
+					running := pod.DeepCopy()
+					running.Status.Phase = corev1.PodRunning
+					running.Status.ContainerStatuses = []corev1.ContainerStatus{
+						{Name: "app", ContainerID: "containerd://started", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+					}
+					fakePodInformer.Update(pending, running)
+				}
+
+				_, err := gardenClient.Create(spec)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, statuses := fakeContainerdClient.LoadTasksArgsForCall(0)
+				Expect(statuses).To(ConsistOf(HaveField("ContainerID", "containerd://started")))
+			})
+
+			It("publishes the pod to the container only once it is running", func() {
+				infos := make(chan garden.ContainerInfo, 1)
+				podStartup = func(pod *corev1.Pod) {
+					// the container is read while its pod is created
+					container, err := gardenClient.Lookup(pod.Name)
+					Expect(err).NotTo(HaveOccurred())
+					info, err := container.Info()
+					Expect(err).NotTo(HaveOccurred())
+					infos <- info
+
+					running := pod.DeepCopy()
+					running.Status.Phase = corev1.PodRunning
+					running.Status.PodIP = "10.244.0.9"
+					fakePodInformer.Add(running)
+				}
+
+				container, err := gardenClient.Create(spec)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(<-infos).To(HaveField("ContainerIP", BeEmpty()))
+
+				info, err := container.Info()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.ContainerIP).To(Equal("10.244.0.9"))
+			})
+
+			It("fails fast with the reason of a container that can not start", func() {
+				podStartup = func(pod *corev1.Pod) {
+					pulling := pod.DeepCopy()
+					pulling.Status.Phase = corev1.PodPending
+					pulling.Status.ContainerStatuses = []corev1.ContainerStatus{
+						{Name: "app", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+							Reason:  "ImagePullBackOff",
+							Message: `Back-off pulling image "cflinuxfs4"`,
+						}}},
+					}
+					fakePodInformer.Update(pod, pulling)
+				}
+
+				_, err := gardenClient.Create(spec)
+				Expect(err).To(MatchError(k8sgarden.PodFailedError{
+					Handle:  "test-container-startup",
+					Reason:  "ImagePullBackOff",
+					Message: `container app: Back-off pulling image "cflinuxfs4"`,
+				}))
+				Expect(fakeContainerdClient.LoadTasksCallCount()).To(BeZero())
+
+				var pod corev1.Pod
+				err = k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "test-container-startup", Namespace: workloadsNamespace}, &pod)
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			})
+
+			It("fails with the reason of a pod that was rejected", func() {
+				podStartup = func(pod *corev1.Pod) {
+					rejected := pod.DeepCopy()
+					rejected.Status.Phase = corev1.PodFailed
+					rejected.Status.Reason = "OutOfmemory"
+					rejected.Status.Message = "Pod was rejected: Node didn't have enough resource: memory"
+					fakePodInformer.Update(pod, rejected)
+				}
+
+				_, err := gardenClient.Create(spec)
+				Expect(err).To(MatchError(ContainSubstring("OutOfmemory: Pod was rejected: Node didn't have enough resource: memory")))
+			})
+
+			It("fails if the pod is deleted before it is running", func() {
+				podStartup = func(pod *corev1.Pod) {
+					fakePodInformer.Delete(pod)
+				}
+
+				_, err := gardenClient.Create(spec)
+				Expect(err).To(MatchError(ContainSubstring("was deleted before it was running")))
+			})
+
+			Context("when the pod is not running within the pod startup timeout", func() {
+				var created chan struct{}
+
+				BeforeEach(func() {
+					k8sConfig.PodStartupTimeout = durationjson.Duration(30 * time.Second)
+					gardenClient, err = k8sgarden.NewClient(
+						clientCtx,
+						logger,
+						k8sClient,
+						fakePodInformer,
+						fakeContainerdClient,
+						fakeKubeletClient,
+						fakeCmdRunner,
+						fakeNstarRunner,
+						fakeUserLookupper,
+						fakeMetronClient,
+						fakeClock,
+						repConfig,
+						k8sConfig,
+						sidecarRootfs,
+						workloadsNamespace,
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					created = make(chan struct{})
+					podStartup = func(pod *corev1.Pod) {
+						pending := pod.DeepCopy()
+						pending.Status.Phase = corev1.PodPending
+						fakePodInformer.Add(pending)
+						close(created)
+					}
+				})
+
+				It("fails once the timeout has passed on the clock", func() {
+					errs := make(chan error, 1)
+					go func() {
+						defer GinkgoRecover()
+						_, err := gardenClient.Create(spec)
+						errs <- err
+					}()
+					Eventually(created).Should(BeClosed())
+
+					// only the pod startup timer is left once the pod was created
+					Eventually(fakeClock.WatcherCount).Should(Equal(1))
+					fakeClock.Increment(29 * time.Second)
+					Consistently(errs).ShouldNot(Receive())
+
+					fakeClock.Increment(time.Second)
+					Eventually(errs).Should(Receive(MatchError("timed out waiting for pod to be running, last phase Pending")))
+
+					var pod corev1.Pod
+					err = k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "test-container-startup", Namespace: workloadsNamespace}, &pod)
+					Expect(apierrors.IsNotFound(err)).To(BeTrue())
+					Expect(gardenClient.Lookup("test-container-startup")).Error().To(MatchError(garden.ContainerNotFoundError{Handle: "test-container-startup"}))
+				})
+			})
+
+			It("fails if a request to the API server does not finish within the API operation timeout", func() {
+				blockRequests["create/Secret"] = true
+
+				errs := make(chan error, 1)
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(done)
+					_, err := gardenClient.Create(spec)
+					errs <- err
+				}()
+
+				// the timer of the blocked request is the only one left, the
+				// network policy was created before
+				Eventually(blockedRequests.Load).Should(Equal(int32(1)))
+				Eventually(fakeClock.WatcherCount).Should(Equal(1))
+				fakeClock.Increment(10 * time.Second)
+				Eventually(errs).Should(Receive(MatchError(context.DeadlineExceeded)))
+				Eventually(done).Should(BeClosed())
+				Expect(gardenClient.Lookup("test-container-startup")).Error().To(HaveOccurred())
+			})
+		})
+
+		Context("when creating the container fails", func() {
+			var spec garden.ContainerSpec
+
+			BeforeEach(func() {
+				fakeContainerdClient.PullReturns(&containerdfakes.FakeImage{
+					NameStub: func() string {
+						return "docker.io/library/busybox:latest"
+					},
+				}, 9999, nil)
+
+				spec = garden.ContainerSpec{
+					Handle: "test-container-rollback",
+					Properties: garden.Properties{
+						executor.ContainerOwnerProperty: "executor",
+					},
+					Limits: garden.Limits{
+						Memory: garden.MemoryLimits{
+							LimitInBytes: 256 * 1024 * 1024,
+						},
+						Disk: garden.DiskLimits{
+							ByteHard: 1024 * 1024 * 1024,
+						},
+					},
+					Image: garden.ImageRef{
+						URI: "docker:///busybox:latest",
+					},
+					NetIn: []garden.NetIn{
+						{ContainerPort: 8080},
+					},
+					NetOut: []garden.NetOutRule{
+						{Protocol: garden.ProtocolTCP},
+					},
+				}
+			})
+
+			DescribeTable("leaves no state behind",
+				func(injectFailure func(), imagePulled bool) {
+					injectFailure()
+
+					_, err := gardenClient.Create(spec)
+					Expect(err).To(HaveOccurred())
+
+					_, err = gardenClient.Lookup(spec.Handle)
+					Expect(err).To(HaveOccurred())
+					containers, err := gardenClient.Containers(garden.Properties{"garden.state": "all"})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(containers).To(BeEmpty())
+
+					pods := &corev1.PodList{}
+					Expect(k8sClient.List(context.Background(), pods, ctrlclient.InNamespace(workloadsNamespace))).To(Succeed())
+					Expect(pods.Items).To(BeEmpty())
+					secrets := &corev1.SecretList{}
+					Expect(k8sClient.List(context.Background(), secrets, ctrlclient.InNamespace(workloadsNamespace))).To(Succeed())
+					Expect(secrets.Items).To(BeEmpty())
+					policies := &networkingv1.NetworkPolicyList{}
+					Expect(k8sClient.List(context.Background(), policies, ctrlclient.InNamespace(workloadsNamespace))).To(Succeed())
+					Expect(policies.Items).To(BeEmpty())
+
+					if imagePulled {
+						Expect(fakeContainerdClient.DeleteCallCount()).To(Equal(1))
+					} else {
+						Expect(fakeContainerdClient.DeleteCallCount()).To(BeZero())
+					}
+
+					// the handle and the host port can be used again
+					failRequests = map[string]bool{}
+					podStartup = nil
+					fakeContainerdClient.PullReturns(&containerdfakes.FakeImage{}, 9999, nil)
+					fakeContainerdClient.LoadTasksReturns(map[string]ctrdclient.Task{"app": fakeTask}, nil)
+					spec.NetIn = []garden.NetIn{{ContainerPort: 8080}}
+					spec.Limits.Disk.ByteHard = 1024 * 1024 * 1024
+
+					_, err = gardenClient.Create(spec)
+					Expect(err).NotTo(HaveOccurred())
+
+					var pod corev1.Pod
+					Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: spec.Handle, Namespace: workloadsNamespace}, &pod)).To(Succeed())
+					Expect(pod.Spec.Containers[0].Ports[0].HostPort).To(Equal(int32(62000)))
+				},
+				Entry("when a host port can not be allocated", func() {
+					k8sConfig.HostPortRangeStart = 62000
+					k8sConfig.HostPortRangeEnd = 62000
+					gardenClient, err = k8sgarden.NewClient(
+						clientCtx,
+						logger,
+						k8sClient,
+						fakePodInformer,
+						fakeContainerdClient,
+						fakeKubeletClient,
+						fakeCmdRunner,
+						fakeNstarRunner,
+						fakeUserLookupper,
+						fakeMetronClient,
+						fakeClock,
+						repConfig,
+						k8sConfig,
+						sidecarRootfs,
+						workloadsNamespace,
+					)
+					Expect(err).NotTo(HaveOccurred())
+					spec.NetIn = append(spec.NetIn, garden.NetIn{ContainerPort: 8081})
+				}, false),
+				Entry("when the image can not be pulled", func() {
+					fakeContainerdClient.PullReturns(nil, 0, errors.New("pull failed"))
+				}, false),
+				Entry("when the image exceeds the disk limit", func() {
+					spec.Limits.Disk.ByteHard = 9999
+				}, true),
+				Entry("when the image config can not be read", func() {
+					fakeContainerdClient.PullReturns(&containerdfakes.FakeImage{
+						SpecStub: func(context.Context) (ocispec.Image, error) {
+							return ocispec.Image{}, errors.New("spec failed")
+						},
+					}, 9999, nil)
+				}, true),
+				Entry("when the network policy can not be created", func() {
+					failRequests["create/NetworkPolicy"] = true
+				}, true),
+				Entry("when the state secret can not be created", func() {
+					failRequests["create/Secret"] = true
+				}, true),
+				Entry("when the pod can not be created", func() {
+					failRequests["create/Pod"] = true
+				}, true),
+				Entry("when the pod fails to start", func() {
+					podStartup = func(pod *corev1.Pod) {
+						failed := pod.DeepCopy()
+						failed.Status.Phase = corev1.PodFailed
+						failed.Status.Reason = "Evicted"
+						fakePodInformer.Update(pod, failed)
+					}
+				}, true),
+				Entry("when the containerd tasks can not be loaded", func() {
+					fakeContainerdClient.LoadTasksReturns(nil, errors.New("containerd connection failed"))
+				}, true),
+				Entry("when the state can not be recorded on the pod", func() {
+					failRequests["patch/Pod"] = true
+				}, true),
+			)
+		})
+
 		It("returns error when containerd task loading fails", func() {
 			fakeContainerdClient.LoadTasksReturns(nil, errors.New("containerd connection failed"))
 
@@ -652,18 +2053,201 @@ var _ = Describe("Client", func() {
 				err = gardenClient.Destroy("container-to-destroy")
 				Expect(err).NotTo(HaveOccurred())
 
-				var pod corev1.Pod
-				err = k8sClient.Get(context.Background(), ctrlclient.ObjectKey{
-					Name:      "container-to-destroy",
-					Namespace: "cf-workloads",
-				}, &pod)
-				Expect(err).To(HaveOccurred())
+				Eventually(func() error {
+					var pod corev1.Pod
+					return k8sClient.Get(context.Background(), ctrlclient.ObjectKey{
+						Name:      "container-to-destroy",
+						Namespace: "cf-workloads",
+					}, &pod)
+				}).Should(Satisfy(apierrors.IsNotFound))
 
 				containers, err = gardenClient.Containers(nil)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(containers).To(BeEmpty())
 			})
 
+			It("removes the port forwarding added with NetIn", func() {
+				container, err := gardenClient.Lookup("container-to-destroy")
+				Expect(err).NotTo(HaveOccurred())
+
+				hostPort, _, err := container.NetIn(0, 8080)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(gardenClient.Destroy("container-to-destroy")).To(Succeed())
+
+				Eventually(fakeCmdRunner).Should(HaveExecutedSerially(fake_command_runner.CommandSpec{
+					Path: "nsenter",
+					Args: []string{
+						"--net=/proc/1/ns/net", "--", "iptables", "-w", "-t", "nat", "-D", "K8S-GARDEN-NETIN",
+						"-p", "tcp", "--dport", fmt.Sprint(hostPort), "-m", "comment", "--comment", "container-to-destroy",
+						"-j", "DNAT", "--to-destination", "10.244.0.10:8080",
+					},
+				}))
+			})
+
+			Context("when the pod is not gone right away", func() {
+				BeforeEach(func() {
+					var pod corev1.Pod
+					Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "container-to-destroy", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+					pod.Finalizers = []string{"test/finalizer"}
+					Expect(k8sClient.Update(context.Background(), &pod)).To(Succeed())
+				})
+
+				removeFinalizer := func() *corev1.Pod {
+					var pod corev1.Pod
+					Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "container-to-destroy", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+					pod.Finalizers = nil
+					Expect(k8sClient.Update(context.Background(), &pod)).To(Succeed())
+					return &pod
+				}
+
+				It("returns right away and keeps the container until the pod is gone", func() {
+					Expect(gardenClient.Destroy("container-to-destroy")).To(Succeed())
+
+					Eventually(func(g Gomega) {
+						var pod corev1.Pod
+						g.Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "container-to-destroy", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+						g.Expect(pod.DeletionTimestamp).NotTo(BeNil())
+					}).Should(Succeed())
+					Expect(gardenClient.Containers(nil)).To(BeEmpty())
+					Consistently(func() error {
+						_, err := gardenClient.Lookup("container-to-destroy")
+						return err
+					}).Should(Succeed())
+
+					removeFinalizer()
+					fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+					Eventually(func() error {
+						_, err := gardenClient.Lookup("container-to-destroy")
+						return err
+					}).Should(MatchError(garden.ContainerNotFoundError{Handle: "container-to-destroy"}))
+				})
+
+				It("cleans up as soon as the pod informer reports the pod deleted", func() {
+					Expect(gardenClient.Destroy("container-to-destroy")).To(Succeed())
+					Eventually(fakeClock.WatcherCount).Should(Equal(1))
+
+					fakePodInformer.Delete(removeFinalizer())
+					Eventually(func() error {
+						_, err := gardenClient.Lookup("container-to-destroy")
+						return err
+					}).Should(HaveOccurred())
+				})
+
+				It("releases the host ports only once the pod is gone", func() {
+					container, err := gardenClient.Lookup("container-to-destroy")
+					Expect(err).NotTo(HaveOccurred())
+					hostPort, _, err := container.NetIn(0, 8080)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(gardenClient.Destroy("container-to-destroy")).To(Succeed())
+					Eventually(fakeClock.WatcherCount).Should(Equal(1))
+
+					other, err := gardenClient.Create(garden.ContainerSpec{
+						Handle: "other-container",
+						Limits: garden.Limits{Disk: garden.DiskLimits{ByteHard: 1024 * 1024 * 1024}},
+						NetIn:  []garden.NetIn{{ContainerPort: 8080}},
+					})
+					Expect(err).NotTo(HaveOccurred())
+					otherInfo, err := other.Info()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(otherInfo.MappedPorts).NotTo(ContainElement(HaveField("HostPort", hostPort)))
+
+					removeFinalizer()
+					fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+					Eventually(func() error {
+						_, err := gardenClient.Lookup("container-to-destroy")
+						return err
+					}).Should(HaveOccurred())
+
+					another, err := gardenClient.Create(garden.ContainerSpec{
+						Handle: "another-container",
+						Limits: garden.Limits{Disk: garden.DiskLimits{ByteHard: 1024 * 1024 * 1024}},
+						NetIn:  []garden.NetIn{{ContainerPort: 8080}},
+					})
+					Expect(err).NotTo(HaveOccurred())
+					anotherInfo, err := another.Info()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(anotherInfo.MappedPorts).To(ContainElement(HaveField("HostPort", hostPort)))
+				})
+			})
+
+			It("does not release the host ports before the cleanup succeeded", func() {
+				container, err := gardenClient.Lookup("container-to-destroy")
+				Expect(err).NotTo(HaveOccurred())
+				hostPort, _, err := container.NetIn(0, 8080)
+				Expect(err).NotTo(HaveOccurred())
+
+				failSecretDeletions.Store(true)
+				Expect(gardenClient.Destroy("container-to-destroy")).To(Succeed())
+				Eventually(logger).Should(gbytes.Say("failed-to-clean-up"))
+
+				other, err := gardenClient.Create(garden.ContainerSpec{
+					Handle: "other-container",
+					Limits: garden.Limits{Disk: garden.DiskLimits{ByteHard: 1024 * 1024 * 1024}},
+					NetIn:  []garden.NetIn{{ContainerPort: 8080}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				otherInfo, err := other.Info()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(otherInfo.MappedPorts).NotTo(ContainElement(HaveField("HostPort", hostPort)))
+
+				failSecretDeletions.Store(false)
+				fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+				Eventually(func() error {
+					_, err := gardenClient.Lookup("container-to-destroy")
+					return err
+				}).Should(HaveOccurred())
+
+				another, err := gardenClient.Create(garden.ContainerSpec{
+					Handle: "another-container",
+					Limits: garden.Limits{Disk: garden.DiskLimits{ByteHard: 1024 * 1024 * 1024}},
+					NetIn:  []garden.NetIn{{ContainerPort: 8080}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				anotherInfo, err := another.Info()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(anotherInfo.MappedPorts).To(ContainElement(HaveField("HostPort", hostPort)))
+			})
+
+			It("stops deleting pods once the context of the client is done", func() {
+				stopClient()
+
+				Expect(gardenClient.Destroy("container-to-destroy")).To(Succeed())
+				Consistently(func(g Gomega) {
+					var pod corev1.Pod
+					g.Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "container-to-destroy", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+					g.Expect(pod.DeletionTimestamp).To(BeNil())
+				}).Should(Succeed())
+			})
+
+			It("deletes the pod with the grace time of the container", func() {
+				container, err := gardenClient.Lookup("container-to-destroy")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(container.SetGraceTime(30 * time.Second)).To(Succeed())
+
+				Expect(gardenClient.Destroy("container-to-destroy")).To(Succeed())
+				Eventually(podDeletionGracePeriods).Should(Equal([]int64{30}))
+			})
+
+			It("retries deleting the pod until it succeeds", func() {
+				failPodDeletions.Store(true)
+
+				Expect(gardenClient.Destroy("container-to-destroy")).To(Succeed())
+				Eventually(fakeClock.WatcherCount).Should(Equal(1))
+				Consistently(func() error {
+					_, err := gardenClient.Lookup("container-to-destroy")
+					return err
+				}).Should(Succeed())
+
+				failPodDeletions.Store(false)
+				fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+				Eventually(func() error {
+					_, err := gardenClient.Lookup("container-to-destroy")
+					return err
+				}).Should(HaveOccurred())
+			})
+
 			It("can be looked up before destroy", func() {
 				container, err := gardenClient.Lookup("container-to-destroy")
 				Expect(err).NotTo(HaveOccurred())
@@ -674,10 +2258,313 @@ var _ = Describe("Client", func() {
 				err := gardenClient.Destroy("container-to-destroy")
 				Expect(err).NotTo(HaveOccurred())
 
-				_, err = gardenClient.Lookup("container-to-destroy")
-				Expect(err).To(HaveOccurred())
-				Expect(err).To(MatchError(garden.ContainerNotFoundError{Handle: "container-to-destroy"}))
+				Eventually(func() error {
+					_, err := gardenClient.Lookup("container-to-destroy")
+					return err
+				}).Should(MatchError(garden.ContainerNotFoundError{Handle: "container-to-destroy"}))
 			})
 		})
 	})
+
+	Describe("image garbage collection", func() {
+		const imageDigest = digest.Digest("sha256:6f2a3bbd0d5cd8de40c8e8a3b1bb9ec3a4d4e7b4d3a5a7f0b1e2c3d4e5f60718")
+
+		newClient := func() {
+			gardenClient, err = k8sgarden.NewClient(
+				clientCtx,
+				logger,
+				k8sClient,
+				fakePodInformer,
+				fakeContainerdClient,
+				fakeKubeletClient,
+				fakeCmdRunner,
+				fakeNstarRunner,
+				fakeUserLookupper,
+				fakeMetronClient,
+				fakeClock,
+				repConfig,
+				k8sConfig,
+				sidecarRootfs,
+				workloadsNamespace,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		createDockerContainer := func(handle string) {
+			_, err := gardenClient.Create(garden.ContainerSpec{
+				Handle: handle,
+				Limits: garden.Limits{Disk: garden.DiskLimits{ByteHard: 1024 * 1024 * 1024}},
+				Image:  garden.ImageRef{URI: "docker:///busybox:latest"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		destroy := func(handle string) {
+			Expect(gardenClient.Destroy(handle)).To(Succeed())
+			Eventually(func() error {
+				_, err := gardenClient.Lookup(handle)
+				return err
+			}).Should(MatchError(garden.ContainerNotFoundError{Handle: handle}))
+		}
+
+		expectImageDeleted := func() {
+			Eventually(fakeContainerdClient.DeleteCallCount).Should(Equal(1))
+			_, name, target := fakeContainerdClient.DeleteArgsForCall(0)
+			Expect(name).To(Equal("docker.io/library/busybox:latest"))
+			Expect(target).To(Equal(imageDigest))
+		}
+
+		BeforeEach(func() {
+			fakeContainerdClient.PullReturns(&containerdfakes.FakeImage{
+				NameStub: func() string {
+					return "docker.io/library/busybox:latest"
+				},
+				TargetStub: func() ocispec.Descriptor {
+					return ocispec.Descriptor{Digest: imageDigest}
+				},
+			}, 9999, nil)
+
+			k8sConfig.ImageGCIdlePeriod = durationjson.Duration(10 * time.Minute)
+			newClient()
+		})
+
+		It("records the digest of the pulled image on the pod", func() {
+			createDockerContainer("docker-container")
+
+			var pod corev1.Pod
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "docker-container", Namespace: workloadsNamespace}, &pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(k8sgarden.ImageDigestAnnotationKey, string(imageDigest)))
+		})
+
+		It("removes an image once no container used it for the idle period", func() {
+			createDockerContainer("docker-container-1")
+			createDockerContainer("docker-container-2")
+
+			destroy("docker-container-1")
+			Consistently(fakeClock.WatcherCount).Should(BeZero())
+
+			destroy("docker-container-2")
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			fakeClock.Increment(9 * time.Minute)
+			Consistently(fakeContainerdClient.DeleteCallCount).Should(BeZero())
+
+			fakeClock.Increment(time.Minute)
+			expectImageDeleted()
+			Eventually(fakeClock.WatcherCount).Should(BeZero())
+		})
+
+		It("keeps an image that is used again within the idle period", func() {
+			createDockerContainer("docker-container-1")
+			destroy("docker-container-1")
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+
+			createDockerContainer("docker-container-2")
+			Eventually(fakeClock.WatcherCount).Should(BeZero())
+			fakeClock.Increment(time.Hour)
+			Consistently(fakeContainerdClient.DeleteCallCount).Should(BeZero())
+		})
+
+		It("keeps images of other names with the same digest while one of them is used", func() {
+			fakeContainerdClient.PullReturnsOnCall(1, &containerdfakes.FakeImage{
+				NameStub: func() string {
+					return "docker.io/library/busybox:1.36"
+				},
+				TargetStub: func() ocispec.Descriptor {
+					return ocispec.Descriptor{Digest: imageDigest}
+				},
+			}, 9999, nil)
+			createDockerContainer("docker-container-1")
+			createDockerContainer("docker-container-2")
+
+			destroy("docker-container-1")
+			fakeClock.Increment(time.Hour)
+			Consistently(fakeContainerdClient.DeleteCallCount).Should(BeZero())
+
+			destroy("docker-container-2")
+			fakeClock.WaitForWatcherAndIncrement(10 * time.Minute)
+			Eventually(fakeContainerdClient.DeleteCallCount).Should(Equal(2))
+			deleted := []string{}
+			for i := range 2 {
+				_, name, target := fakeContainerdClient.DeleteArgsForCall(i)
+				Expect(target).To(Equal(imageDigest))
+				deleted = append(deleted, name)
+			}
+			Expect(deleted).To(ConsistOf("docker.io/library/busybox:latest", "docker.io/library/busybox:1.36"))
+		})
+
+		It("restores the images in use from the pods", func() {
+			createDockerContainer("docker-container")
+			newClient()
+
+			destroy("docker-container")
+			fakeClock.WaitForWatcherAndIncrement(10 * time.Minute)
+			expectImageDeleted()
+		})
+
+		It("retries removing images that could not be deleted", func() {
+			fakeContainerdClient.DeleteReturnsOnCall(0, errors.New("delete failed"))
+			createDockerContainer("docker-container")
+			destroy("docker-container")
+
+			fakeClock.WaitForWatcherAndIncrement(10 * time.Minute)
+			Eventually(fakeContainerdClient.DeleteCallCount).Should(Equal(1))
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(fakeContainerdClient.DeleteCallCount).Should(Equal(2))
+			Eventually(fakeClock.WatcherCount).Should(BeZero())
+		})
+
+		Context("when the image store is above the threshold", func() {
+			BeforeEach(func() {
+				k8sConfig.ImageGCThresholdBytes = 1024
+				newClient()
+				fakeContainerdClient.ImageStoreUsageReturnsOnCall(0, 2048, nil)
+				fakeContainerdClient.ImageStoreUsageReturnsOnCall(1, 512, nil)
+			})
+
+			It("removes unused images without waiting for the idle period", func() {
+				createDockerContainer("docker-container")
+				Consistently(fakeContainerdClient.ImageStoreUsageCallCount).Should(BeZero())
+
+				destroy("docker-container")
+				expectImageDeleted()
+				Expect(fakeContainerdClient.ImageStoreUsageCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("pulling docker images", func() {
+		var spec garden.ContainerSpec
+
+		newClient := func() {
+			gardenClient, err = k8sgarden.NewClient(
+				clientCtx,
+				logger,
+				k8sClient,
+				fakePodInformer,
+				fakeContainerdClient,
+				fakeKubeletClient,
+				fakeCmdRunner,
+				fakeNstarRunner,
+				fakeUserLookupper,
+				fakeMetronClient,
+				fakeClock,
+				repConfig,
+				k8sConfig,
+				sidecarRootfs,
+				workloadsNamespace,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		create := func() <-chan error {
+			errs := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				_, err := gardenClient.Create(spec)
+				errs <- err
+			}()
+			return errs
+		}
+
+		BeforeEach(func() {
+			spec = garden.ContainerSpec{
+				Handle: "docker-container",
+				Limits: garden.Limits{Disk: garden.DiskLimits{ByteHard: 1024 * 1024 * 1024}},
+				Image:  garden.ImageRef{URI: "docker:///busybox:latest"},
+			}
+			fakeContainerdClient.PullReturns(&containerdfakes.FakeImage{
+				NameStub: func() string {
+					return "docker.io/library/busybox:latest"
+				},
+			}, 9999, nil)
+		})
+
+		It("retries pulls with exponential backoff while the registry is rate limited", func() {
+			rateLimited := remoteerrors.ErrUnexpectedStatus{Status: "429 Too Many Requests", StatusCode: http.StatusTooManyRequests}
+			fakeContainerdClient.PullReturnsOnCall(0, nil, 0, rateLimited)
+			fakeContainerdClient.PullReturnsOnCall(1, nil, 0, rateLimited)
+
+			errs := create()
+
+			Eventually(logger).Should(gbytes.Say(`"attempt":1`))
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			fakeClock.Increment(time.Second)
+
+			Eventually(logger).Should(gbytes.Say(`"attempt":2`))
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			fakeClock.Increment(time.Second)
+			Consistently(fakeContainerdClient.PullCallCount).Should(Equal(2))
+			fakeClock.Increment(time.Second)
+
+			Eventually(errs).Should(Receive(BeNil()))
+			Expect(fakeContainerdClient.PullCallCount()).To(Equal(3))
+		})
+
+		It("gives up after the configured attempts", func() {
+			k8sConfig.ImagePullAttempts = 2
+			newClient()
+			fakeContainerdClient.PullReturns(nil, 0, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+
+			errs := create()
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			fakeClock.Increment(time.Second)
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError(k8sgarden.ErrImageRegistryUnreachable))
+			Expect(fakeContainerdClient.PullCallCount()).To(Equal(2))
+		})
+
+		It("times out pulls from registries that do not respond", func() {
+			k8sConfig.ImagePullTimeout = durationjson.Duration(time.Minute)
+			k8sConfig.ImagePullAttempts = 1
+			newClient()
+			fakeContainerdClient.PullStub = func(ctx context.Context, _, _, _ string, _ time.Duration) (ctrdclient.Image, int64, error) {
+				<-ctx.Done()
+				return nil, 0, ctx.Err()
+			}
+
+			errs := create()
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError(k8sgarden.ErrImageRegistryUnreachable))
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		DescribeTable("classifies pull failures",
+			func(pullErr error, kind error) {
+				k8sConfig.ImagePullAttempts = 1
+				newClient()
+				fakeContainerdClient.PullReturns(nil, 0, pullErr)
+
+				_, err := gardenClient.Create(spec)
+				Expect(err).To(MatchError(kind))
+
+				var imagePullErr k8sgarden.ImagePullError
+				Expect(errors.As(err, &imagePullErr)).To(BeTrue())
+				Expect(imagePullErr.Image).To(Equal("busybox:latest"))
+				Expect(imagePullErr.Err).To(Equal(pullErr))
+				Expect(err.Error()).To(ContainSubstring(kind.Error()))
+			},
+			Entry("denied access", remoteerrors.ErrUnexpectedStatus{Status: "401 Unauthorized", StatusCode: http.StatusUnauthorized}, k8sgarden.ErrImageUnauthorized),
+			Entry("invalid credentials", fmt.Errorf("pull access denied: %w", docker.ErrInvalidAuthorization), k8sgarden.ErrImageUnauthorized),
+			Entry("unknown tags", fmt.Errorf("docker.io/library/busybox:latest: %w", errdefs.ErrNotFound), k8sgarden.ErrImageNotFound),
+			Entry("other platforms", fmt.Errorf("no match for platform in manifest: %w", errdefs.ErrNotFound), k8sgarden.ErrImageManifestUnsupported),
+			Entry("schema 1 manifests", fmt.Errorf("%w: media type is no longer supported", errdefs.ErrNotImplemented), k8sgarden.ErrImageManifestUnsupported),
+			Entry("rate limits", remoteerrors.ErrUnexpectedStatus{Status: "429 Too Many Requests", StatusCode: http.StatusTooManyRequests}, k8sgarden.ErrImageRateLimited),
+			Entry("unavailable registries", remoteerrors.ErrUnexpectedStatus{Status: "503 Service Unavailable", StatusCode: http.StatusServiceUnavailable}, k8sgarden.ErrImageRegistryUnreachable),
+			Entry("network errors", &net.DNSError{Err: "no such host", Name: "registry.example.com"}, k8sgarden.ErrImageRegistryUnreachable),
+		)
+
+		It("does not retry other failures", func() {
+			fakeContainerdClient.PullReturns(nil, 0, errors.New("pull failed"))
+
+			_, err := gardenClient.Create(spec)
+			Expect(err).To(MatchError(ContainSubstring("failed to pull docker image busybox:latest: pull failed")))
+			Expect(errors.As(err, new(k8sgarden.ImagePullError))).To(BeFalse())
+			Expect(fakeContainerdClient.PullCallCount()).To(Equal(1))
+		})
+	})
 })
@@ -0,0 +1,157 @@
+package k8sgarden
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/durationjson"
+)
+
+const (
+	defaultHostPortRangeStart uint32 = 62000
+	defaultHostPortRangeEnd   uint32 = math.MaxUint16
+)
+
+// Config holds the settings of the garden client. They are read from the
+// k8s_rep section of the rep configuration file, which the upstream rep
+// configuration does not know about.
+type Config struct {
+	ContainerConfigPath string `json:"container_config_path,omitempty"`
+	// RegistryConfigPath is a directory in the hosts.toml layout of
+	// containerd, e.g. /etc/containerd/certs.d, configuring the registries
+	// docker images are pulled from.
+	RegistryConfigPath string `json:"registry_config_path,omitempty"`
+	// ImagePullSecrets are the names of kubernetes.io/dockerconfigjson
+	// secrets in the workloads namespace. Their credentials are used for
+	// docker images pulled without credentials, and by kubelet.
+	ImagePullSecrets []string `json:"image_pull_secrets,omitempty"`
+	// DisableBandwidthLimits drops the bandwidth limits of containers, for
+	// CNIs without support for the bandwidth annotations.
+	DisableBandwidthLimits bool `json:"disable_bandwidth_limits,omitempty"`
+	// HostPortRangeStart and HostPortRangeEnd limit the host ports handed out
+	// to containers, both inclusive. They default to 62000 and 65535.
+	HostPortRangeStart uint32 `json:"host_port_range_start,omitempty"`
+	HostPortRangeEnd   uint32 `json:"host_port_range_end,omitempty"`
+	// PodStartupTimeout, APIOperationTimeout and PollInterval bound the
+	// Kubernetes operations, see [Timeouts]. They default to 2m, 10s and
+	// 500ms.
+	PodStartupTimeout   durationjson.Duration `json:"pod_startup_timeout,omitempty"`
+	APIOperationTimeout durationjson.Duration `json:"api_operation_timeout,omitempty"`
+	PollInterval        durationjson.Duration `json:"poll_interval,omitempty"`
+	// ImageGCIdlePeriod and ImageGCThresholdBytes control the removal of
+	// pulled images no container uses anymore, see [ImageGC]. The idle period
+	// defaults to 1h, the threshold is disabled by default.
+	ImageGCIdlePeriod     durationjson.Duration `json:"image_gc_idle_period,omitempty"`
+	ImageGCThresholdBytes int64                 `json:"image_gc_threshold_bytes,omitempty"`
+	// ImagePullTimeout and ImagePullAttempts bound the pulls of docker
+	// images, see [ImagePull]. They default to 5m and 3.
+	ImagePullTimeout  durationjson.Duration `json:"image_pull_timeout,omitempty"`
+	ImagePullAttempts int                   `json:"image_pull_attempts,omitempty"`
+}
+
+// HostPortRange returns the range of host ports handed out to containers,
+// with the defaults applied.
+func (c Config) HostPortRange() (uint32, uint32) {
+	start, end := c.HostPortRangeStart, c.HostPortRangeEnd
+	if start == 0 {
+		start = defaultHostPortRangeStart
+	}
+	if end == 0 {
+		end = defaultHostPortRangeEnd
+	}
+
+	return start, end
+}
+
+// Timeouts returns the timeouts of the Kubernetes operations, with the
+// defaults applied.
+func (c Config) Timeouts() Timeouts {
+	timeouts := Timeouts{
+		PodStartup:   time.Duration(c.PodStartupTimeout),
+		APIOperation: time.Duration(c.APIOperationTimeout),
+		PollInterval: time.Duration(c.PollInterval),
+	}
+	if timeouts.PodStartup == 0 {
+		timeouts.PodStartup = defaultPodStartupTimeout
+	}
+	if timeouts.APIOperation == 0 {
+		timeouts.APIOperation = defaultAPIOperationTimeout
+	}
+	if timeouts.PollInterval == 0 {
+		timeouts.PollInterval = defaultPollInterval
+	}
+
+	return timeouts
+}
+
+// ImageGC returns the settings of the image garbage collection, with the
+// defaults applied.
+func (c Config) ImageGC() ImageGC {
+	imageGC := ImageGC{
+		IdlePeriod: time.Duration(c.ImageGCIdlePeriod),
+		Threshold:  c.ImageGCThresholdBytes,
+	}
+	if imageGC.IdlePeriod == 0 {
+		imageGC.IdlePeriod = defaultImageGCIdlePeriod
+	}
+
+	return imageGC
+}
+
+// ImagePull returns the settings of docker image pulls, with the defaults
+// applied.
+func (c Config) ImagePull() ImagePull {
+	imagePull := ImagePull{
+		Timeout:  time.Duration(c.ImagePullTimeout),
+		Attempts: c.ImagePullAttempts,
+	}
+	if imagePull.Timeout == 0 {
+		imagePull.Timeout = defaultImagePullTimeout
+	}
+	if imagePull.Attempts == 0 {
+		imagePull.Attempts = defaultImagePullAttempts
+	}
+
+	return imagePull
+}
+
+// NewConfig reads the k8s_rep section of the rep configuration file at the
+// given path.
+func NewConfig(configPath string) (Config, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return Config{}, err
+	}
+
+	repConfig := struct {
+		K8sRep Config `json:"k8s_rep"`
+	}{}
+	if err := json.Unmarshal(data, &repConfig); err != nil {
+		return Config{}, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	start, end := repConfig.K8sRep.HostPortRange()
+	if start > end || end > math.MaxUint16 {
+		return Config{}, fmt.Errorf("invalid host port range %d-%d", start, end)
+	}
+
+	timeouts := repConfig.K8sRep.Timeouts()
+	if timeouts.PodStartup < 0 || timeouts.APIOperation < 0 || timeouts.PollInterval < 0 {
+		return Config{}, fmt.Errorf("invalid timeouts %+v", timeouts)
+	}
+
+	imageGC := repConfig.K8sRep.ImageGC()
+	if imageGC.IdlePeriod < 0 || imageGC.Threshold < 0 {
+		return Config{}, fmt.Errorf("invalid image garbage collection settings %+v", imageGC)
+	}
+
+	imagePull := repConfig.K8sRep.ImagePull()
+	if imagePull.Timeout < 0 || imagePull.Attempts < 0 {
+		return Config{}, fmt.Errorf("invalid image pull settings %+v", imagePull)
+	}
+
+	return repConfig.K8sRep, nil
+}
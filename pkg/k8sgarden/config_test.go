@@ -0,0 +1,127 @@
+package k8sgarden_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/durationjson"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var configPath string
+
+	BeforeEach(func() {
+		configPath = filepath.Join(GinkgoT().TempDir(), "rep.json")
+	})
+
+	It("reads the k8s_rep section of the rep config", func() {
+		Expect(os.WriteFile(configPath, []byte(`{
+			"cell_id": "cell",
+			"k8s_rep": {
+				"container_config_path": "/var/lib/rep/container_config",
+				"registry_config_path": "/etc/containerd/certs.d",
+				"image_pull_secrets": ["artifactory"],
+				"disable_bandwidth_limits": true,
+				"host_port_range_start": 61000,
+				"host_port_range_end": 61999,
+				"pod_startup_timeout": "5m",
+				"api_operation_timeout": "30s",
+				"poll_interval": "1s",
+				"image_gc_idle_period": "30m",
+				"image_gc_threshold_bytes": 10737418240,
+				"image_pull_timeout": "10m",
+				"image_pull_attempts": 5
+			}
+		}`), 0644)).To(Succeed())
+
+		config, err := k8sgarden.NewConfig(configPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(Equal(k8sgarden.Config{
+			ContainerConfigPath:    "/var/lib/rep/container_config",
+			RegistryConfigPath:     "/etc/containerd/certs.d",
+			ImagePullSecrets:       []string{"artifactory"},
+			DisableBandwidthLimits: true,
+			HostPortRangeStart:     61000,
+			HostPortRangeEnd:       61999,
+			PodStartupTimeout:      durationjson.Duration(5 * time.Minute),
+			APIOperationTimeout:    durationjson.Duration(30 * time.Second),
+			PollInterval:           durationjson.Duration(time.Second),
+			ImageGCIdlePeriod:      durationjson.Duration(30 * time.Minute),
+			ImageGCThresholdBytes:  10737418240,
+			ImagePullTimeout:       durationjson.Duration(10 * time.Minute),
+			ImagePullAttempts:      5,
+		}))
+		Expect(config.Timeouts()).To(Equal(k8sgarden.Timeouts{
+			PodStartup:   5 * time.Minute,
+			APIOperation: 30 * time.Second,
+			PollInterval: time.Second,
+		}))
+		Expect(config.ImageGC()).To(Equal(k8sgarden.ImageGC{
+			IdlePeriod: 30 * time.Minute,
+			Threshold:  10737418240,
+		}))
+		Expect(config.ImagePull()).To(Equal(k8sgarden.ImagePull{
+			Timeout:  10 * time.Minute,
+			Attempts: 5,
+		}))
+	})
+
+	It("returns the defaults without a k8s_rep section", func() {
+		Expect(os.WriteFile(configPath, []byte(`{"cell_id": "cell"}`), 0644)).To(Succeed())
+
+		config, err := k8sgarden.NewConfig(configPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(BeZero())
+
+		start, end := config.HostPortRange()
+		Expect(start).To(Equal(uint32(62000)))
+		Expect(end).To(Equal(uint32(65535)))
+
+		Expect(config.Timeouts()).To(Equal(k8sgarden.Timeouts{
+			PodStartup:   2 * time.Minute,
+			APIOperation: 10 * time.Second,
+			PollInterval: 500 * time.Millisecond,
+		}))
+		Expect(config.ImageGC()).To(Equal(k8sgarden.ImageGC{IdlePeriod: time.Hour}))
+		Expect(config.ImagePull()).To(Equal(k8sgarden.ImagePull{Timeout: 5 * time.Minute, Attempts: 3}))
+	})
+
+	It("returns an error for invalid host port ranges", func() {
+		Expect(os.WriteFile(configPath, []byte(`{"k8s_rep": {"host_port_range_start": 62000, "host_port_range_end": 61000}}`), 0644)).To(Succeed())
+
+		_, err := k8sgarden.NewConfig(configPath)
+		Expect(err).To(MatchError(ContainSubstring("invalid host port range")))
+	})
+
+	It("returns an error for negative timeouts", func() {
+		Expect(os.WriteFile(configPath, []byte(`{"k8s_rep": {"api_operation_timeout": "-1s"}}`), 0644)).To(Succeed())
+
+		_, err := k8sgarden.NewConfig(configPath)
+		Expect(err).To(MatchError(ContainSubstring("invalid timeouts")))
+	})
+
+	It("returns an error for negative image garbage collection settings", func() {
+		Expect(os.WriteFile(configPath, []byte(`{"k8s_rep": {"image_gc_threshold_bytes": -1}}`), 0644)).To(Succeed())
+
+		_, err := k8sgarden.NewConfig(configPath)
+		Expect(err).To(MatchError(ContainSubstring("invalid image garbage collection settings")))
+	})
+
+	It("returns an error for negative image pull settings", func() {
+		Expect(os.WriteFile(configPath, []byte(`{"k8s_rep": {"image_pull_attempts": -1}}`), 0644)).To(Succeed())
+
+		_, err := k8sgarden.NewConfig(configPath)
+		Expect(err).To(MatchError(ContainSubstring("invalid image pull settings")))
+	})
+
+	It("returns an error for invalid config files", func() {
+		Expect(os.WriteFile(configPath, []byte(`{`), 0644)).To(Succeed())
+
+		_, err := k8sgarden.NewConfig(configPath)
+		Expect(err).To(MatchError(ContainSubstring("failed to parse")))
+	})
+})
@@ -1,22 +1,40 @@
 package k8sgarden
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 	"sync"
+	"syscall"
 	"time"
 
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/executor"
 	"code.cloudfoundry.org/garden"
 	"code.cloudfoundry.org/guardian/gardener"
 	"code.cloudfoundry.org/guardian/rundmc"
 	"code.cloudfoundry.org/guardian/rundmc/goci"
 	"code.cloudfoundry.org/guardian/rundmc/processes"
 	"code.cloudfoundry.org/guardian/rundmc/users"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/containerd"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/kubelet"
 	"code.cloudfoundry.org/lager/v3"
 	ctrdclient "github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/pkg/cio"
+	"github.com/containerd/errdefs"
 	"github.com/google/uuid"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	corev1 "k8s.io/api/core/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultStopGraceTime = 10 * time.Second
+	stopPollInterval     = 100 * time.Millisecond
 )
 
 var (
@@ -24,16 +42,33 @@ var (
 )
 
 type container struct {
-	log             lager.Logger
-	pod             *corev1.Pod
-	env             []string
-	cpuAssignment   float64
-	rootfsSize      uint64
-	nstar           rundmc.NstarRunner
-	userLookupper   users.UserLookupper
-	taskMap         map[string]ctrdclient.Task
-	propertyManager gardener.PropertyManager
-	mu              sync.RWMutex
+	log              lager.Logger
+	pod              *corev1.Pod
+	env              []string
+	cpuAssignment    float64
+	rootfsSize       uint64
+	nstar            rundmc.NstarRunner
+	userLookupper    users.UserLookupper
+	taskMap          map[string]ctrdclient.Task
+	propertyManager  gardener.PropertyManager
+	containerdClient containerd.Client
+	kubeletClient    kubelet.Client
+	k8sclient        ctrlclient.Client
+	deadlines        deadlines
+	portManager      PortManager
+	portForwarder    PortForwarder
+	processes        map[string]*process
+	stopped          bool
+	destroying       bool
+	portsReleased    bool
+	termination      *PodFailedError
+	mu               sync.RWMutex
+
+	// attachMu makes sure that the fifos of a process are only opened once
+	attachMu sync.Mutex
+	// patchMu serializes the changes of the container to the annotations of
+	// its pod, which are made without holding mu
+	patchMu sync.Mutex
 }
 
 func NewContainer(
@@ -46,30 +81,63 @@ func NewContainer(
 	propertyManager gardener.PropertyManager,
 	rootfsSize uint64,
 	taskMap map[string]ctrdclient.Task,
+	containerdClient containerd.Client,
+	kubeletClient kubelet.Client,
+	k8sclient ctrlclient.Client,
+	clk clock.Clock,
+	timeouts Timeouts,
+	portManager PortManager,
+	portForwarder PortForwarder,
 ) *container {
 	return &container{
-		log:             log,
-		pod:             pod,
-		env:             env,
-		cpuAssignment:   cpuAssignment,
-		rootfsSize:      rootfsSize,
-		nstar:           nstar,
-		userLookupper:   userLookupper,
-		taskMap:         taskMap,
-		propertyManager: propertyManager,
-		mu:              sync.RWMutex{},
+		log:              log,
+		pod:              pod,
+		env:              env,
+		cpuAssignment:    cpuAssignment,
+		rootfsSize:       rootfsSize,
+		nstar:            nstar,
+		userLookupper:    userLookupper,
+		taskMap:          taskMap,
+		propertyManager:  propertyManager,
+		containerdClient: containerdClient,
+		kubeletClient:    kubeletClient,
+		k8sclient:        k8sclient,
+		deadlines:        newDeadlines(clk, timeouts),
+		portManager:      portManager,
+		portForwarder:    portForwarder,
+		processes:        map[string]*process{},
+		mu:               sync.RWMutex{},
 	}
 }
 
 // Handle implements [garden.Container].
 func (c *container) Handle() string {
-	return c.pod.GetName()
+	return c.currentPod().GetName()
 }
 
+// stoppedState is the state in the info of containers that were stopped or
+// whose pods terminated.
+const stoppedState = "stopped"
+
 // Info implements [garden.Container].
 func (c *container) Info() (garden.ContainerInfo, error) {
+	return c.infoFromPod(c.currentPod()), nil
+}
+
+// currentPod returns the pod of the container, which is replaced when the
+// container changes it.
+func (c *container) currentPod() *corev1.Pod {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.pod
+}
+
+// infoFromPod builds the container info from the given pod, which may be a
+// fresher copy than the one stored on the container.
+func (c *container) infoFromPod(pod *corev1.Pod) garden.ContainerInfo {
 	portMapping := []garden.PortMapping{}
-	for _, container := range c.pod.Spec.Containers {
+	for _, container := range pod.Spec.Containers {
 		if len(container.Ports) == 0 {
 			continue
 		}
@@ -82,15 +150,28 @@ func (c *container) Info() (garden.ContainerInfo, error) {
 		}
 	}
 
+	netInMappings, err := netInMappings(pod)
+	if err != nil {
+		c.log.Error("failed-to-get-net-in-mappings", err)
+	}
+	portMapping = append(portMapping, netInMappings...)
+
+	state := "active"
+	c.mu.RLock()
+	if c.stopped || c.termination != nil || podStopped(pod) {
+		state = stoppedState
+	}
+	c.mu.RUnlock()
+
 	return garden.ContainerInfo{
-		State:         "active",
-		Events:        []string{},
-		HostIP:        c.pod.Status.HostIP,
-		ContainerIP:   c.pod.Status.PodIP,
+		State:         state,
+		Events:        podEvents(pod),
+		HostIP:        pod.Status.HostIP,
+		ContainerIP:   pod.Status.PodIP,
 		ContainerIPv6: "",
-		ExternalIP:    c.pod.Status.HostIP,
+		ExternalIP:    pod.Status.HostIP,
 		MappedPorts:   portMapping,
-	}, nil
+	}
 }
 
 // Run implements [garden.Container].
@@ -122,6 +203,15 @@ func (c *container) Run(spec garden.ProcessSpec, io garden.ProcessIO) (garden.Pr
 		},
 		NoNewPrivileges: false,
 	}
+	if spec.TTY != nil {
+		processSpec.Terminal = true
+		if spec.TTY.WindowSize != nil {
+			processSpec.ConsoleSize = &specs.Box{
+				Width:  uint(spec.TTY.WindowSize.Columns),
+				Height: uint(spec.TTY.WindowSize.Rows),
+			}
+		}
+	}
 	processSpec.Env = processes.UnixEnvFor(goci.Bndl{Spec: specs.Spec{Process: processSpec}}, spec, execUser.Uid)
 	processSpec.Env = append(processSpec.Env, spec.Env...)
 
@@ -134,13 +224,50 @@ func (c *container) Run(spec garden.ProcessSpec, io garden.ProcessIO) (garden.Pr
 		id = uuid.NewString()
 	}
 
-	return NewProcess(
+	var proc *process
+	proc = newProcess(
 		c.log.Session("process", lager.Data{"processID": id}),
 		id,
 		processSpec,
 		io,
 		task,
-	), nil
+		func() { c.untrackProcess(proc) },
+	)
+
+	if err := c.trackProcess(proc); err != nil {
+		return nil, err
+	}
+
+	if err := proc.start(); err != nil {
+		c.untrackProcess(proc)
+		c.log.Error("failed-to-start-process", err, lager.Data{"processID": id})
+		return nil, fmt.Errorf("failed to start process %s: %w", id, err)
+	}
+
+	return proc, nil
+}
+
+// trackProcess adds the process to the registry of the container, rejecting
+// IDs of processes that are still running.
+func (c *container) trackProcess(proc *process) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.processes[proc.ID()]; ok {
+		return fmt.Errorf("process ID '%s' already in use", proc.ID())
+	}
+
+	c.processes[proc.ID()] = proc
+	return nil
+}
+
+func (c *container) untrackProcess(proc *process) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.processes[proc.ID()] == proc {
+		delete(c.processes, proc.ID())
+	}
 }
 
 // StreamIn implements [garden.Container].
@@ -184,66 +311,496 @@ func (c *container) Property(name string) (string, error) {
 
 func (c *container) SetProperty(name string, value string) error {
 	c.propertyManager.Set(c.Handle(), name, value)
-	return nil
+	return c.persistProperties(name)
 }
 
 func (c *container) RemoveProperty(name string) error {
 	// we explicitly stopped handling this in 2016, see git blame + commit log -- COPIED FROM GARDEN
 	_ = c.propertyManager.Remove(c.Handle(), name)
-	return nil
+	return c.persistProperties(name)
 }
 
 func (c *container) SetGraceTime(t time.Duration) error {
 	c.propertyManager.Set(c.Handle(), gardener.GraceTimeKey, fmt.Sprintf("%d", t))
+	return c.persistProperties(gardener.GraceTimeKey)
+}
+
+// persistProperties records the properties of the container on its pod after
+// the named property changed, so that they survive a restart of the rep.
+// Sensitive properties are recorded in the state secret instead.
+func (c *container) persistProperties(name string) error {
+	c.patchMu.Lock()
+	defer c.patchMu.Unlock()
+
+	pod := c.currentPod()
+
+	all, err := c.propertyManager.All(pod.Name)
+	if err != nil {
+		return err
+	}
+	properties := maps.Clone(all)
+
+	if sensitiveProperties[name] {
+		return applyStateSecret(c.k8sclient, c.deadlines, pod.Name, pod.Namespace, c.env, properties)
+	}
+
+	annotations, err := stateAnnotations(properties, c.cpuAssignment)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations[PropertiesAnnotationKey] == annotations[PropertiesAnnotationKey] {
+		return nil
+	}
+
+	if err := c.patchAnnotations(pod, annotations); err != nil {
+		c.log.Error("failed-to-persist-properties", err)
+		return fmt.Errorf("failed to record properties on pod: %w", err)
+	}
+
+	return nil
+}
+
+// patchAnnotations sets the annotations on the pod and on the copy of the
+// container. It must be called with patchMu held, pod is the current pod of
+// the container and is not modified.
+func (c *container) patchAnnotations(pod *corev1.Pod, annotations map[string]string) error {
+	ctx, cancel := c.deadlines.apiContext()
+	defer cancel()
+
+	patched := pod.DeepCopy()
+	if patched.Annotations == nil {
+		patched.Annotations = map[string]string{}
+	}
+	maps.Copy(patched.Annotations, annotations)
+	if err := c.k8sclient.Patch(ctx, patched, ctrlclient.MergeFrom(pod)); err != nil {
+		return err
+	}
+
+	// the status is kept, the pod controller may have taken over a newer one
+	// in the meantime
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pod.Status.DeepCopyInto(&patched.Status)
+	patched.DeletionTimestamp = c.pod.DeletionTimestamp.DeepCopy()
+	c.pod = patched
+
 	return nil
 }
 
 // Attach implements [garden.Container].
+//
+// The output of processes that are tracked already is written to the IO as
+// well. Other processes, e.g. ones that were started before the rep
+// restarted, have their IO re-opened through their fifos.
 func (c *container) Attach(processID string, io garden.ProcessIO) (garden.Process, error) {
-	panic("unimplemented")
+	log := c.log.Session("attach", lager.Data{"processID": processID})
+
+	log.Info("started")
+	defer log.Info("finished")
+
+	c.attachMu.Lock()
+	defer c.attachMu.Unlock()
+
+	c.mu.RLock()
+	tracked, isTracked := c.processes[processID]
+	c.mu.RUnlock()
+
+	if isTracked {
+		tracked.attach(io)
+		return tracked, nil
+	}
+
+	for _, task := range []ctrdclient.Task{c.taskMap[appContainerName], c.taskMap[sidecarContainerName]} {
+		if task == nil {
+			continue
+		}
+
+		var proc *process
+		proc, err := attachedProcess(
+			c.log.Session("process", lager.Data{"processID": processID}),
+			processID,
+			io,
+			task,
+			func() { c.untrackProcess(proc) },
+			func(attach cio.Attach) (ctrdclient.Process, error) {
+				return task.LoadProcess(context.Background(), processID, attach)
+			},
+		)
+		if errdefs.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			log.Error("failed-to-attach-to-process", err)
+			return nil, fmt.Errorf("failed to attach to process %s: %w", processID, err)
+		}
+
+		// processes from before a restart are not known yet
+		_ = c.trackProcess(proc)
+
+		return proc, nil
+	}
+
+	return nil, garden.ProcessNotFoundError{ProcessID: processID}
 }
 
 // BulkNetOut implements [garden.Container].
 func (c *container) BulkNetOut(netOutRules []garden.NetOutRule) error {
-	panic("unimplemented")
+	return applyNetOutRules(c.log, c.k8sclient, c.deadlines, c.Handle(), c.currentPod().Namespace, netOutRules)
 }
 
 // CurrentBandwidthLimits implements [garden.Container].
 func (c *container) CurrentBandwidthLimits() (garden.BandwidthLimits, error) {
-	panic("unimplemented")
+	return podBandwidthLimits(c.currentPod())
 }
 
 // CurrentCPULimits implements [garden.Container].
 func (c *container) CurrentCPULimits() (garden.CPULimits, error) {
-	panic("unimplemented")
+	return garden.CPULimits{
+		Weight: uint64(c.cpuAssignment * cpuSharesPerCore),
+	}, nil
 }
 
 // CurrentDiskLimits implements [garden.Container].
+//
+// The ephemeral storage limit of the pod does not include the image, so the
+// rootfs size is added to it.
 func (c *container) CurrentDiskLimits() (garden.DiskLimits, error) {
-	panic("unimplemented")
+	pod := c.currentPod()
+	for _, ctr := range pod.Spec.Containers {
+		if ctr.Name != appContainerName {
+			continue
+		}
+
+		return garden.DiskLimits{
+			ByteHard: uint64(ctr.Resources.Limits.StorageEphemeral().Value()) + c.rootfsSize,
+			Scope:    garden.DiskLimitScopeTotal,
+		}, nil
+	}
+
+	return garden.DiskLimits{}, fmt.Errorf("no %s container found in pod %s", appContainerName, pod.Name)
 }
 
 // CurrentMemoryLimits implements [garden.Container].
 func (c *container) CurrentMemoryLimits() (garden.MemoryLimits, error) {
-	panic("unimplemented")
+	pod := c.currentPod()
+	if pod.Spec.Resources == nil {
+		return garden.MemoryLimits{}, nil
+	}
+
+	return garden.MemoryLimits{
+		LimitInBytes: uint64(pod.Spec.Resources.Limits.Memory().Value()),
+	}, nil
 }
 
 // Metrics implements [garden.Container].
 func (c *container) Metrics() (garden.Metrics, error) {
-	panic("unimplemented")
+	task, ok := c.taskMap[appContainerName]
+	if !ok {
+		return garden.Metrics{}, fmt.Errorf("no task found for container %s", c.Handle())
+	}
+
+	metrics, err := c.containerdClient.Metrics(context.Background(), task)
+	if err != nil {
+		return garden.Metrics{}, fmt.Errorf("failed to get metrics for container %s: %w", c.Handle(), err)
+	}
+
+	// the disk usage is the ephemeral storage of the pod reported by kubelet,
+	// as in BulkMetrics, which includes the emptyDir volumes and logs
+	podMetrics, err := c.kubeletClient.GetMetrics(c.log, []string{c.Handle()})
+	if err != nil {
+		return garden.Metrics{}, fmt.Errorf("failed to get metrics from kubelet for container %s: %w", c.Handle(), err)
+	}
+	metrics.DiskUsageInBytes = podMetrics[c.Handle()].DiskUsageInBytes
+
+	if startTime := c.currentPod().Status.StartTime; startTime != nil {
+		metrics.ContainerAgeInNanoseconds = uint64(time.Since(startTime.Time).Nanoseconds())
+	}
+
+	return c.gardenMetrics(metrics), nil
+}
+
+// gardenMetrics converts the metrics reported by kubelet or containerd into
+// garden metrics, accounting for the rootfs and the CPU entitlement.
+func (c *container) gardenMetrics(metrics executor.ContainerMetrics) garden.Metrics {
+	gardenMetrics := garden.Metrics{
+		MemoryStat: garden.ContainerMemoryStat{
+			TotalUsageTowardLimit: metrics.MemoryUsageInBytes,
+		},
+		CPUStat: garden.ContainerCPUStat{
+			Usage: uint64(metrics.TimeSpentInCPU),
+		},
+		DiskStat: garden.ContainerDiskStat{
+			TotalBytesUsed: metrics.DiskUsageInBytes + c.rootfsSize,
+		},
+		Age:            time.Duration(metrics.ContainerAgeInNanoseconds),
+		CPUEntitlement: uint64(c.cpuAssignment * float64(metrics.ContainerAgeInNanoseconds)),
+	}
+
+	if metrics.RxInBytes != nil && metrics.TxInBytes != nil {
+		gardenMetrics.NetworkStat = &garden.ContainerNetworkStat{
+			RxBytes: *metrics.RxInBytes,
+			TxBytes: *metrics.TxInBytes,
+		}
+	}
+
+	return gardenMetrics
 }
 
 // NetIn implements [garden.Container].
+//
+// Host ports of a pod can not be changed after it was created, so the mapping
+// is added as DNAT rule on the node and recorded in an annotation of the pod.
 func (c *container) NetIn(hostPort uint32, containerPort uint32) (uint32, uint32, error) {
-	panic("unimplemented")
+	log := c.log.Session("net-in", lager.Data{"host-port": hostPort, "container-port": containerPort})
+
+	log.Info("starting")
+	defer log.Info("finished")
+
+	if hostPort == 0 {
+		var err error
+		hostPort, err = c.portManager.Next()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to allocate host port: %w", err)
+		}
+	} else if err := c.portManager.Acquire(hostPort); err != nil {
+		return 0, 0, fmt.Errorf("failed to allocate host port: %w", err)
+	}
+	if containerPort == 0 {
+		containerPort = hostPort
+	}
+	mapping := garden.PortMapping{HostPort: hostPort, ContainerPort: containerPort}
+
+	if err := c.addNetIn(mapping); err != nil {
+		log.Error("failed-to-add-net-in", err)
+		c.portManager.Release(hostPort)
+		return 0, 0, err
+	}
+
+	return hostPort, containerPort, nil
+}
+
+func (c *container) addNetIn(mapping garden.PortMapping) error {
+	c.patchMu.Lock()
+	defer c.patchMu.Unlock()
+
+	pod := c.currentPod()
+
+	mappings, err := netInMappings(pod)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(append(mappings, mapping))
+	if err != nil {
+		return fmt.Errorf("failed to encode port mappings: %w", err)
+	}
+
+	if err := c.portForwarder.Forward(pod.Name, pod.Status.PodIP, mapping); err != nil {
+		return fmt.Errorf("failed to forward host port %d: %w", mapping.HostPort, err)
+	}
+
+	if err := c.patchAnnotations(pod, map[string]string{NetInAnnotationKey: string(value)}); err != nil {
+		return errors.Join(
+			fmt.Errorf("failed to record port mapping on pod: %w", err),
+			c.portForwarder.Remove(pod.Name, pod.Status.PodIP, mapping),
+		)
+	}
+
+	return nil
+}
+
+// removeNetIns removes the forwards added with NetIn. Their host ports are
+// released by releasePorts.
+func (c *container) removeNetIns() error {
+	pod := c.currentPod()
+
+	mappings, err := netInMappings(pod)
+	if err != nil {
+		return err
+	}
+
+	for _, mapping := range mappings {
+		if err := c.portForwarder.Remove(pod.Name, pod.Status.PodIP, mapping); err != nil {
+			return fmt.Errorf("failed to remove forward of host port %d: %w", mapping.HostPort, err)
+		}
+	}
+
+	return nil
+}
+
+// releasePorts releases the host ports of the pod and the ones added with
+// NetIn. It does nothing when called again, as the ports may have been handed
+// out to another container in the meantime.
+func (c *container) releasePorts() {
+	c.mu.Lock()
+	if c.portsReleased {
+		c.mu.Unlock()
+		return
+	}
+	c.portsReleased = true
+	pod := c.pod
+	c.mu.Unlock()
+
+	ports := podHostPorts(pod)
+	// the mappings were parsed by removeNetIns already
+	mappings, _ := netInMappings(pod)
+	for _, mapping := range mappings {
+		ports = append(ports, mapping.HostPort)
+	}
+
+	for _, port := range ports {
+		c.portManager.Release(port)
+	}
 }
 
 // NetOut implements [garden.Container].
 func (c *container) NetOut(netOutRule garden.NetOutRule) error {
-	panic("unimplemented")
+	return applyNetOutRules(c.log, c.k8sclient, c.deadlines, c.Handle(), c.currentPod().Namespace, []garden.NetOutRule{netOutRule})
 }
 
 // Stop implements [garden.Container].
+//
+// All processes started with Run receive SIGTERM first and SIGKILL once the
+// grace time of the container has passed, or SIGKILL right away if kill is set.
+// The pod itself keeps running until the container is destroyed.
 func (c *container) Stop(kill bool) error {
-	panic("unimplemented")
+	log := c.log.Session("stop", lager.Data{"kill": kill})
+
+	log.Info("started")
+	defer log.Info("finished")
+
+	if !kill {
+		c.signalProcesses(log, syscall.SIGTERM)
+
+		graceTime := c.graceTime()
+		if !c.waitForProcesses(graceTime) {
+			log.Info("processes-still-running-after-grace-time", lager.Data{"grace-time": graceTime.String()})
+		}
+	}
+	c.signalProcesses(log, syscall.SIGKILL)
+
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+
+	return c.SetProperty("garden.state", stoppedState)
+}
+
+// markDestroying marks the container as destroyed by Destroy, while its pod
+// is still being deleted. It reports whether the container was not marked
+// before.
+func (c *container) markDestroying() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	marked := !c.destroying
+	c.destroying = true
+	return marked
+}
+
+func (c *container) isDestroying() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.destroying
+}
+
+// setPod replaces the pod of the container, once Create has created it. The
+// pod must not be modified afterwards.
+func (c *container) setPod(pod *corev1.Pod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pod = pod
+}
+
+// updatePodStatus takes over the status of the pod as seen by the pod
+// controller. The metadata and the spec of the pod are only changed by the
+// container itself, so that a stale copy can not undo its changes.
+func (c *container) updatePodStatus(pod *corev1.Pod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := c.pod.DeepCopy()
+	pod.Status.DeepCopyInto(&updated.Status)
+	updated.DeletionTimestamp = pod.DeletionTimestamp.DeepCopy()
+	c.pod = updated
+}
+
+// markTerminated records why the pod of the container terminated. It reports
+// whether the container was not marked before. The reason is only set in
+// memory, callers persist it with persistProperties while the pod exists.
+func (c *container) markTerminated(reason PodFailedError) bool {
+	c.mu.Lock()
+	if c.termination != nil {
+		c.mu.Unlock()
+		return false
+	}
+	c.termination = &reason
+	c.mu.Unlock()
+
+	c.propertyManager.Set(c.Handle(), TerminationReasonProperty, fmt.Sprintf("%s: %s", reason.Reason, reason.Message))
+	return true
+}
+
+// graceTime returns the grace time stored by SetGraceTime, falling back to
+// defaultStopGraceTime if none has been set.
+func (c *container) graceTime() time.Duration {
+	property, ok := c.propertyManager.Get(c.Handle(), gardener.GraceTimeKey)
+	if !ok {
+		return defaultStopGraceTime
+	}
+
+	var graceTime time.Duration
+	if _, err := fmt.Sscanf(property, "%d", &graceTime); err != nil || graceTime <= 0 {
+		return defaultStopGraceTime
+	}
+
+	return graceTime
+}
+
+func (c *container) signalProcesses(log lager.Logger, signal syscall.Signal) {
+	for _, proc := range c.trackedProcesses() {
+		ctrdProcess, ok := proc.running(context.Background())
+		if !ok {
+			continue
+		}
+
+		if err := ctrdProcess.Kill(context.Background(), signal); err != nil {
+			log.Error("failed-to-signal-process", err, lager.Data{"processID": proc.ID(), "signal": signal})
+		}
+	}
+}
+
+// waitForProcesses waits until none of the tracked processes is running
+// anymore and reports whether that happened within the timeout.
+func (c *container) waitForProcesses(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		running := false
+		for _, proc := range c.trackedProcesses() {
+			if _, ok := proc.running(ctx); ok {
+				running = true
+				break
+			}
+		}
+		if !running {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(stopPollInterval):
+		}
+	}
+}
+
+func (c *container) trackedProcesses() []*process {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.Collect(maps.Values(c.processes))
 }
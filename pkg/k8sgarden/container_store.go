@@ -0,0 +1,565 @@
+package k8sgarden
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/executor"
+	"code.cloudfoundry.org/executor/depot/containerstore"
+	"code.cloudfoundry.org/executor/depot/event"
+	"code.cloudfoundry.org/garden"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/tedsuo/ifrit"
+)
+
+// ExecutorContainerProperty holds the executor's view of a container, which
+// is handed back to the container store after a restart of the rep.
+const ExecutorContainerProperty = "executor:container"
+
+// RestoredContainers are the executor containers whose pods survived a
+// restart of the rep. Their processes keep running, but the executor can not
+// resume the steps that monitored them, which is left to the
+// [NewContainerMonitor].
+type RestoredContainers struct {
+	containers map[string]executor.Container
+	lock       *sync.RWMutex
+}
+
+// RestoreContainers collects the containers of the owner that the container
+// store can take over again. Containers without a recorded executor state
+// can not be taken over and are destroyed, as the rep did on every start.
+// Only running containers keep running, the others are restored as crashed.
+func RestoreContainers(logger lager.Logger, client garden.Client, owner string) (*RestoredContainers, error) {
+	log := logger.Session("restore-executor-containers")
+
+	gardenContainers, err := client.Containers(garden.Properties{
+		executor.ContainerOwnerProperty: owner,
+		executor.ContainerStateProperty: "all",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	restored := &RestoredContainers{
+		containers: make(map[string]executor.Container),
+		lock:       &sync.RWMutex{},
+	}
+	for _, gardenContainer := range gardenContainers {
+		handle := gardenContainer.Handle()
+
+		container, err := executorContainer(gardenContainer)
+		if err != nil {
+			log.Info("destroying-unrestorable-container", lager.Data{"handle": handle, "reason": err.Error()})
+			if err := client.Destroy(handle); err != nil {
+				return nil, fmt.Errorf("failed to destroy container %s: %w", handle, err)
+			}
+			continue
+		}
+
+		log.Info("restored-container", lager.Data{"guid": container.Guid})
+		restored.containers[container.Guid] = container
+	}
+
+	return restored, nil
+}
+
+func executorContainer(gardenContainer garden.Container) (executor.Container, error) {
+	value, err := gardenContainer.Property(ExecutorContainerProperty)
+	if err != nil {
+		return executor.Container{}, err
+	}
+
+	var container executor.Container
+	if err := json.Unmarshal([]byte(value), &container); err != nil {
+		return executor.Container{}, fmt.Errorf("failed to decode property %s: %w", ExecutorContainerProperty, err)
+	}
+
+	restoreState(&container, gardenContainer)
+
+	return container, nil
+}
+
+// restoreState completes the container unless it was recorded as running and
+// its garden container still is. The steps that would start the processes of
+// containers that were not running yet are gone with the previous rep.
+func restoreState(container *executor.Container, gardenContainer garden.Container) {
+	if container.State == executor.StateCompleted {
+		return
+	}
+
+	info, err := gardenContainer.Info()
+	switch {
+	case err != nil:
+		container.TransitionToComplete(true, fmt.Sprintf("failed to get container info: %s", err), false)
+	case info.State == stoppedState:
+		container.TransitionToComplete(true, stopReason(gardenContainer), false)
+	case container.State != executor.StateRunning:
+		container.TransitionToComplete(true, "container was not running when the rep restarted", false)
+	}
+}
+
+// stopReason returns why the garden container stopped, which is the reason
+// its pod terminated if known.
+func stopReason(gardenContainer garden.Container) string {
+	reason, err := gardenContainer.Property(TerminationReasonProperty)
+	if err != nil || reason == "" {
+		return "container stopped"
+	}
+
+	return reason
+}
+
+func (r *RestoredContainers) Get(guid string) (executor.Container, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	container, ok := r.containers[guid]
+	return container.Copy(), ok
+}
+
+func (r *RestoredContainers) List() []executor.Container {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	list := make([]executor.Container, 0, len(r.containers))
+	for _, container := range r.containers {
+		list = append(list, container.Copy())
+	}
+
+	return list
+}
+
+func (r *RestoredContainers) Contains(guid string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	_, ok := r.containers[guid]
+	return ok
+}
+
+func (r *RestoredContainers) update(guid string, modify func(*executor.Container)) (executor.Container, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	container, ok := r.containers[guid]
+	if !ok {
+		return executor.Container{}, false
+	}
+
+	modify(&container)
+	r.containers[guid] = container
+
+	return container.Copy(), true
+}
+
+func (r *RestoredContainers) remove(guid string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.containers, guid)
+}
+
+type containerStore struct {
+	containerstore.ContainerStore
+
+	client   garden.Client
+	hub      event.Hub
+	restored *RestoredContainers
+
+	// reserveMu makes checking the remaining resources and reserving them
+	// one step, as the wrapped store does not know the restored containers.
+	reserveMu sync.Mutex
+}
+
+var _ containerstore.ContainerStore = &containerStore{}
+
+// NewContainerStore returns a container store that serves the restored
+// containers next to the containers of the wrapped store, and records the
+// executor state of new containers so that they can be restored.
+func NewContainerStore(store containerstore.ContainerStore, client garden.Client, hub event.Hub, restored *RestoredContainers) containerstore.ContainerStore {
+	return &containerStore{
+		ContainerStore: store,
+		client:         client,
+		hub:            hub,
+		restored:       restored,
+	}
+}
+
+func (s *containerStore) Reserve(logger lager.Logger, traceID string, req *executor.AllocationRequest) (executor.Container, error) {
+	s.reserveMu.Lock()
+	defer s.reserveMu.Unlock()
+
+	if s.restored.Contains(req.Guid) {
+		return executor.Container{}, executor.ErrContainerGuidNotAvailable
+	}
+
+	remaining := s.RemainingResources(logger)
+	if !remaining.Subtract(&req.Resource) {
+		return executor.Container{}, executor.ErrInsufficientResourcesAvailable
+	}
+
+	return s.ContainerStore.Reserve(logger, traceID, req)
+}
+
+func (s *containerStore) Create(logger lager.Logger, traceID string, guid string) (executor.Container, error) {
+	container, err := s.ContainerStore.Create(logger, traceID, guid)
+	if err != nil {
+		return container, err
+	}
+
+	record(logger, s.client, container)
+
+	return container, nil
+}
+
+func (s *containerStore) Update(logger lager.Logger, req *executor.UpdateRequest) error {
+	container, ok := s.restored.update(req.Guid, func(container *executor.Container) {
+		if req.InternalRoutes != nil {
+			container.InternalRoutes = req.InternalRoutes
+		}
+		if req.MetricTags != nil {
+			container.LogConfig.Tags = req.MetricTags
+			container.MetricsConfig.Tags = req.MetricTags
+		}
+	})
+	if !ok {
+		if err := s.ContainerStore.Update(logger, req); err != nil {
+			return err
+		}
+
+		container, err := s.ContainerStore.Get(logger, req.Guid)
+		if err != nil {
+			return err
+		}
+		record(logger, s.client, container)
+
+		return nil
+	}
+
+	record(logger, s.client, container)
+
+	return nil
+}
+
+// Stop completes a restored container. Its processes are stopped when the
+// container is destroyed.
+func (s *containerStore) Stop(logger lager.Logger, traceID string, guid string) error {
+	completed := false
+	container, ok := s.restored.update(guid, func(container *executor.Container) {
+		if container.State == executor.StateCompleted {
+			return
+		}
+		container.RunResult.Stopped = true
+		container.TransitionToComplete(false, "", false)
+		completed = true
+	})
+	if !ok {
+		return s.ContainerStore.Stop(logger, traceID, guid)
+	}
+
+	if completed {
+		go s.hub.Emit(executor.NewContainerCompleteEvent(container, traceID))
+	}
+
+	return nil
+}
+
+func (s *containerStore) Destroy(logger lager.Logger, traceID string, guid string) error {
+	if !s.restored.Contains(guid) {
+		return s.ContainerStore.Destroy(logger, traceID, guid)
+	}
+
+	if err := s.client.Destroy(guid); err != nil {
+		if _, ok := err.(garden.ContainerNotFoundError); !ok {
+			logger.Error("failed-to-destroy-restored-container", err, lager.Data{"guid": guid})
+			return err
+		}
+	}
+
+	s.restored.remove(guid)
+
+	return nil
+}
+
+func (s *containerStore) Get(logger lager.Logger, guid string) (executor.Container, error) {
+	if container, ok := s.restored.Get(guid); ok {
+		return container, nil
+	}
+
+	return s.ContainerStore.Get(logger, guid)
+}
+
+func (s *containerStore) List(logger lager.Logger) []executor.Container {
+	return append(s.ContainerStore.List(logger), s.restored.List()...)
+}
+
+func (s *containerStore) Metrics(logger lager.Logger) (map[string]executor.ContainerMetrics, error) {
+	containerMetrics, err := s.ContainerStore.Metrics(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := map[string]executor.Container{}
+	for _, container := range s.restored.List() {
+		if container.State == executor.StateRunning {
+			restored[container.Guid] = container
+		}
+	}
+	if len(restored) == 0 {
+		return containerMetrics, nil
+	}
+
+	handles := make([]string, 0, len(restored))
+	for guid := range restored {
+		handles = append(handles, guid)
+	}
+
+	gardenMetrics, err := s.client.BulkMetrics(handles)
+	if err != nil {
+		logger.Error("failed-to-get-metrics-of-restored-containers", err)
+		return nil, err
+	}
+
+	for guid, container := range restored {
+		entry, ok := gardenMetrics[guid]
+		if !ok || entry.Err != nil {
+			continue
+		}
+
+		var rxInBytes, txInBytes *uint64
+		if entry.Metrics.NetworkStat != nil {
+			rxInBytes = &entry.Metrics.NetworkStat.RxBytes
+			txInBytes = &entry.Metrics.NetworkStat.TxBytes
+		}
+
+		containerMetrics[guid] = executor.ContainerMetrics{
+			MemoryUsageInBytes:                  entry.Metrics.MemoryStat.TotalUsageTowardLimit,
+			DiskUsageInBytes:                    entry.Metrics.DiskStat.TotalBytesUsed,
+			MemoryLimitInBytes:                  container.MemoryLimit,
+			DiskLimitInBytes:                    container.DiskLimit,
+			TimeSpentInCPU:                      time.Duration(entry.Metrics.CPUStat.Usage),
+			ContainerAgeInNanoseconds:           uint64(entry.Metrics.Age),
+			AbsoluteCPUEntitlementInNanoseconds: entry.Metrics.CPUEntitlement,
+			RxInBytes:                           rxInBytes,
+			TxInBytes:                           txInBytes,
+		}
+	}
+
+	return containerMetrics, nil
+}
+
+func (s *containerStore) RemainingResources(logger lager.Logger) executor.ExecutorResources {
+	remaining := s.ContainerStore.RemainingResources(logger)
+	for _, container := range s.restored.List() {
+		remaining.Subtract(&container.Resource)
+	}
+	s.limitToHostPorts(logger, &remaining)
+
+	return remaining
+}
+
+// hostPortCounter is implemented by garden clients that hand out host ports
+// from a limited range, like the one returned by [NewClient].
+type hostPortCounter interface {
+	FreeHostPorts() (int, error)
+}
+
+// limitToHostPorts limits the remaining containers to the free host ports, so
+// that containers are rejected when they are reserved instead of crashing
+// when they are created. Every container that is not created yet is taken to
+// need at least one host port.
+func (s *containerStore) limitToHostPorts(logger lager.Logger, remaining *executor.ExecutorResources) {
+	counter, ok := s.client.(hostPortCounter)
+	if !ok {
+		return
+	}
+
+	free, err := counter.FreeHostPorts()
+	if err != nil {
+		logger.Error("failed-to-count-free-host-ports", err)
+		return
+	}
+
+	for _, container := range s.ContainerStore.List(logger) {
+		if container.State == executor.StateReserved || container.State == executor.StateInitializing {
+			free--
+		}
+	}
+
+	remaining.Containers = max(min(remaining.Containers, free), 0)
+}
+
+func (s *containerStore) GetFiles(logger lager.Logger, guid, sourcePath string) (io.ReadCloser, error) {
+	if !s.restored.Contains(guid) {
+		return s.ContainerStore.GetFiles(logger, guid, sourcePath)
+	}
+
+	gardenContainer, err := s.client.Lookup(guid)
+	if err != nil {
+		return nil, err
+	}
+
+	return gardenContainer.StreamOut(garden.StreamOutSpec{Path: sourcePath, User: "root"})
+}
+
+// record stores the executor state of the container in a property of its
+// garden container. Failing to do so only prevents restoring the container.
+func record(logger lager.Logger, client garden.Client, container executor.Container) {
+	gardenContainer, err := client.Lookup(container.Guid)
+	if errors.As(err, &garden.ContainerNotFoundError{}) {
+		return
+	}
+	if err != nil {
+		logger.Error("failed-to-lookup-container", err, lager.Data{"guid": container.Guid})
+		return
+	}
+
+	value, err := json.Marshal(container)
+	if err != nil {
+		logger.Error("failed-to-encode-container", err, lager.Data{"guid": container.Guid})
+		return
+	}
+
+	if err := gardenContainer.SetProperty(ExecutorContainerProperty, string(value)); err != nil {
+		logger.Error("failed-to-record-container", err, lager.Data{"guid": container.Guid})
+	}
+}
+
+type containerMonitor struct {
+	logger   lager.Logger
+	client   garden.Client
+	hub      event.Hub
+	restored *RestoredContainers
+	clock    clock.Clock
+	interval time.Duration
+}
+
+// NewContainerMonitor returns a runner that records the state of containers
+// when they start running or complete, so that they are restored in it, and
+// completes the restored containers whose garden containers stopped or are
+// gone, checking them every interval.
+func NewContainerMonitor(logger lager.Logger, client garden.Client, hub event.Hub, restored *RestoredContainers, clk clock.Clock, interval time.Duration) ifrit.Runner {
+	return &containerMonitor{
+		logger:   logger,
+		client:   client,
+		hub:      hub,
+		restored: restored,
+		clock:    clk,
+		interval: interval,
+	}
+}
+
+func (m *containerMonitor) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := m.logger.Session("container-monitor")
+
+	source, err := m.hub.Subscribe()
+	if err != nil {
+		logger.Error("failed-to-subscribe", err)
+		return err
+	}
+
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		m.recordEvents(logger.Session("record-events"), source)
+	}()
+
+	timer := m.clock.NewTimer(m.interval)
+	defer timer.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-timer.C():
+			m.completeStoppedContainers(logger.Session("complete-stopped-containers"))
+
+		case signal := <-signals:
+			logger.Info("signalled", lager.Data{"signal": signal.String()})
+			_ = source.Close()
+			<-recorded
+			return nil
+		}
+
+		timer.Reset(m.interval)
+	}
+}
+
+// recordEvents records the containers of running and complete events until
+// the source is closed.
+func (m *containerMonitor) recordEvents(logger lager.Logger, source executor.EventSource) {
+	for {
+		ev, err := source.Next()
+		if err != nil {
+			return
+		}
+
+		switch ev := ev.(type) {
+		case executor.ContainerRunningEvent:
+			record(logger, m.client, ev.Container())
+		case executor.ContainerCompleteEvent:
+			record(logger, m.client, ev.Container())
+		}
+	}
+}
+
+// completeStoppedContainers reports the restored containers that stopped as
+// crashed, as no step of the executor watches their processes.
+func (m *containerMonitor) completeStoppedContainers(logger lager.Logger) {
+	for _, container := range m.restored.List() {
+		if container.State != executor.StateRunning {
+			continue
+		}
+
+		reason, stopped := m.stopped(logger, container.Guid)
+		if !stopped {
+			continue
+		}
+
+		completed := false
+		container, ok := m.restored.update(container.Guid, func(container *executor.Container) {
+			if container.State != executor.StateRunning {
+				return
+			}
+			container.TransitionToComplete(true, reason, false)
+			completed = true
+		})
+		if !ok || !completed {
+			continue
+		}
+
+		logger.Info("completed-container", lager.Data{"guid": container.Guid, "reason": reason})
+		m.hub.Emit(executor.NewContainerCompleteEvent(container, ""))
+	}
+}
+
+// stopped returns whether the garden container of the handle stopped or is
+// gone, and why. Containers that can not be looked up for other reasons are
+// checked again on the next run.
+func (m *containerMonitor) stopped(logger lager.Logger, handle string) (string, bool) {
+	gardenContainer, err := m.client.Lookup(handle)
+	if errors.As(err, &garden.ContainerNotFoundError{}) {
+		return "container not found", true
+	}
+	if err != nil {
+		logger.Error("failed-to-lookup-container", err, lager.Data{"guid": handle})
+		return "", false
+	}
+
+	info, err := gardenContainer.Info()
+	if err != nil {
+		logger.Error("failed-to-get-container-info", err, lager.Data{"guid": handle})
+		return "", false
+	}
+	if info.State != stoppedState {
+		return "", false
+	}
+
+	return stopReason(gardenContainer), true
+}
@@ -0,0 +1,393 @@
+package k8sgarden_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/executor"
+	"code.cloudfoundry.org/executor/depot/containerstore"
+	"code.cloudfoundry.org/executor/depot/containerstore/containerstorefakes"
+	"code.cloudfoundry.org/executor/depot/event"
+	"code.cloudfoundry.org/garden"
+	"code.cloudfoundry.org/garden/gardenfakes"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("ContainerStore", func() {
+	var (
+		logger           *lagertest.TestLogger
+		gardenClient     *gardenfakes.FakeClient
+		gardenContainers map[string]*gardenfakes.FakeContainer
+		containersMu     sync.Mutex
+	)
+
+	addGardenContainer := func(container executor.Container, state string) *gardenfakes.FakeContainer {
+		value, err := json.Marshal(container)
+		Expect(err).NotTo(HaveOccurred())
+
+		gardenContainer := &gardenfakes.FakeContainer{}
+		gardenContainer.HandleReturns(container.Guid)
+		gardenContainer.InfoReturns(garden.ContainerInfo{State: state}, nil)
+		gardenContainer.PropertyStub = func(name string) (string, error) {
+			if name == k8sgarden.ExecutorContainerProperty {
+				return string(value), nil
+			}
+			return "", garden.NewError("property not found")
+		}
+
+		containersMu.Lock()
+		defer containersMu.Unlock()
+		gardenContainers[container.Guid] = gardenContainer
+
+		return gardenContainer
+	}
+
+	removeGardenContainer := func(handle string) {
+		containersMu.Lock()
+		defer containersMu.Unlock()
+
+		delete(gardenContainers, handle)
+	}
+
+	newContainer := func(guid string, state executor.State) executor.Container {
+		return executor.Container{
+			Guid:     guid,
+			State:    state,
+			Resource: executor.Resource{MemoryMB: 1024, DiskMB: 1024},
+		}
+	}
+
+	allocationRequest := func(guid string) *executor.AllocationRequest {
+		req := executor.NewAllocationRequest(guid, &executor.Resource{MemoryMB: 1024, DiskMB: 1024}, false, nil)
+		return &req
+	}
+
+	restore := func() *k8sgarden.RestoredContainers {
+		restored, err := k8sgarden.RestoreContainers(logger, gardenClient, "executor")
+		Expect(err).NotTo(HaveOccurred())
+		return restored
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("container-store")
+		gardenContainers = map[string]*gardenfakes.FakeContainer{}
+
+		gardenClient = &gardenfakes.FakeClient{}
+		gardenClient.ContainersStub = func(garden.Properties) ([]garden.Container, error) {
+			containersMu.Lock()
+			defer containersMu.Unlock()
+
+			containers := []garden.Container{}
+			for _, container := range gardenContainers {
+				containers = append(containers, container)
+			}
+			return containers, nil
+		}
+		gardenClient.LookupStub = func(handle string) (garden.Container, error) {
+			containersMu.Lock()
+			defer containersMu.Unlock()
+
+			container, ok := gardenContainers[handle]
+			if !ok {
+				return nil, garden.ContainerNotFoundError{Handle: handle}
+			}
+			return container, nil
+		}
+	})
+
+	Describe("RestoreContainers", func() {
+		It("lists the containers of the owner in all states", func() {
+			restore()
+
+			Expect(gardenClient.ContainersCallCount()).To(Equal(1))
+			Expect(gardenClient.ContainersArgsForCall(0)).To(Equal(garden.Properties{
+				executor.ContainerOwnerProperty: "executor",
+				executor.ContainerStateProperty: "all",
+			}))
+		})
+
+		It("restores running containers whose garden containers are active", func() {
+			addGardenContainer(newContainer("running", executor.StateRunning), "active")
+
+			container, ok := restore().Get("running")
+			Expect(ok).To(BeTrue())
+			Expect(container.State).To(Equal(executor.StateRunning))
+			Expect(container.MemoryMB).To(Equal(1024))
+		})
+
+		It("keeps the result of completed containers", func() {
+			completed := newContainer("completed", executor.StateCompleted)
+			completed.RunResult = executor.ContainerRunResult{Failed: true, FailureReason: "exited"}
+			addGardenContainer(completed, "stopped")
+
+			container, ok := restore().Get("completed")
+			Expect(ok).To(BeTrue())
+			Expect(container.State).To(Equal(executor.StateCompleted))
+			Expect(container.RunResult.FailureReason).To(Equal("exited"))
+		})
+
+		It("completes running containers whose garden containers stopped with the termination reason", func() {
+			gardenContainer := addGardenContainer(newContainer("stopped", executor.StateRunning), "stopped")
+			gardenContainer.PropertyStub = func(name string) (string, error) {
+				if name == k8sgarden.TerminationReasonProperty {
+					return "OOMKilled: container app exited with code 137: ", nil
+				}
+				value, err := json.Marshal(newContainer("stopped", executor.StateRunning))
+				return string(value), err
+			}
+
+			container, ok := restore().Get("stopped")
+			Expect(ok).To(BeTrue())
+			Expect(container.State).To(Equal(executor.StateCompleted))
+			Expect(container.RunResult.Failed).To(BeTrue())
+			Expect(container.RunResult.FailureReason).To(Equal("OOMKilled: container app exited with code 137: "))
+		})
+
+		It("completes containers that were not running yet", func() {
+			addGardenContainer(newContainer("created", executor.StateCreated), "active")
+
+			container, ok := restore().Get("created")
+			Expect(ok).To(BeTrue())
+			Expect(container.State).To(Equal(executor.StateCompleted))
+			Expect(container.RunResult.Failed).To(BeTrue())
+			Expect(container.RunResult.FailureReason).To(Equal("container was not running when the rep restarted"))
+		})
+
+		It("destroys containers without a recorded executor state", func() {
+			gardenContainer := addGardenContainer(newContainer("unrecorded", executor.StateRunning), "active")
+			gardenContainer.PropertyStub = nil
+			gardenContainer.PropertyReturns("", garden.NewError("property not found"))
+
+			Expect(restore().Contains("unrecorded")).To(BeFalse())
+			Expect(gardenClient.DestroyCallCount()).To(Equal(1))
+			Expect(gardenClient.DestroyArgsForCall(0)).To(Equal("unrecorded"))
+		})
+	})
+
+	Describe("Reserve", func() {
+		var (
+			innerStore *containerstorefakes.FakeContainerStore
+			store      containerstore.ContainerStore
+			reserved   atomic.Int32
+		)
+
+		BeforeEach(func() {
+			addGardenContainer(newContainer("restored", executor.StateRunning), "active")
+
+			reserved.Store(0)
+			innerStore = &containerstorefakes.FakeContainerStore{}
+			innerStore.RemainingResourcesStub = func(lager.Logger) executor.ExecutorResources {
+				return executor.ExecutorResources{
+					MemoryMB:   4096 - 1024*int(reserved.Load()),
+					DiskMB:     4096 - 1024*int(reserved.Load()),
+					Containers: 10 - int(reserved.Load()),
+				}
+			}
+			innerStore.ReserveStub = func(_ lager.Logger, _ string, req *executor.AllocationRequest) (executor.Container, error) {
+				// widen the window between checking and reserving
+				time.Sleep(time.Millisecond)
+				reserved.Add(1)
+				return executor.NewReservedContainerFromAllocationRequest(req, time.Now().UnixNano()), nil
+			}
+
+			store = k8sgarden.NewContainerStore(innerStore, gardenClient, event.NewHub(), restore())
+		})
+
+		It("subtracts the resources of restored containers", func() {
+			Expect(store.RemainingResources(logger)).To(Equal(executor.ExecutorResources{MemoryMB: 3072, DiskMB: 3072, Containers: 9}))
+		})
+
+		It("does not reserve the guid of a restored container", func() {
+			_, err := store.Reserve(logger, "", allocationRequest("restored"))
+			Expect(err).To(MatchError(executor.ErrContainerGuidNotAvailable))
+			Expect(innerStore.ReserveCallCount()).To(BeZero())
+		})
+
+		It("does not reserve more than the resources left by restored containers", func() {
+			for _, guid := range []string{"a", "b", "c"} {
+				_, err := store.Reserve(logger, "", allocationRequest(guid))
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			_, err := store.Reserve(logger, "", allocationRequest("d"))
+			Expect(err).To(MatchError(executor.ErrInsufficientResourcesAvailable))
+		})
+
+		It("does not oversubscribe the resources when reserving concurrently", func() {
+			var wg sync.WaitGroup
+			var succeeded atomic.Int32
+			for i := range 10 {
+				wg.Go(func() {
+					defer GinkgoRecover()
+
+					_, err := store.Reserve(logger, "", allocationRequest(fmt.Sprintf("container-%d", i)))
+					if err == nil {
+						succeeded.Add(1)
+					} else {
+						Expect(err).To(MatchError(executor.ErrInsufficientResourcesAvailable))
+					}
+				})
+			}
+			wg.Wait()
+
+			Expect(succeeded.Load()).To(Equal(int32(3)))
+			Expect(innerStore.ReserveCallCount()).To(Equal(3))
+		})
+	})
+
+	Context("with the store of the executor", func() {
+		var (
+			client *hostPortsClient
+			store  containerstore.ContainerStore
+		)
+
+		BeforeEach(func() {
+			client = &hostPortsClient{FakeClient: gardenClient, free: 2}
+			totalCapacity := executor.NewExecutorResources(8192, 8192, 10)
+			store = k8sgarden.NewContainerStore(containerstore.New(
+				containerstore.ContainerConfig{},
+				&totalCapacity,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				fakeclock.NewFakeClock(time.Now()),
+				event.NewHub(),
+				nil,
+				"",
+				nil,
+				nil,
+				"",
+				nil,
+				"",
+				false,
+				false,
+				nil,
+				json.Marshal,
+			), client, event.NewHub(), restore())
+		})
+
+		It("limits the remaining containers to the free host ports", func() {
+			Expect(store.RemainingResources(logger)).To(Equal(executor.NewExecutorResources(8192, 8192, 2)))
+		})
+
+		It("rejects containers once the free host ports are taken by reserved containers", func() {
+			for _, guid := range []string{"a", "b"} {
+				_, err := store.Reserve(logger, "", allocationRequest(guid))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(store.RemainingResources(logger).Containers).To(BeZero())
+
+			_, err := store.Reserve(logger, "", allocationRequest("c"))
+			Expect(err).To(MatchError(executor.ErrInsufficientResourcesAvailable))
+		})
+
+		It("rejects containers when no host ports are free", func() {
+			client.free = 0
+
+			_, err := store.Reserve(logger, "", allocationRequest("a"))
+			Expect(err).To(MatchError(executor.ErrInsufficientResourcesAvailable))
+		})
+	})
+
+	Describe("ContainerMonitor", func() {
+		var (
+			hub      event.Hub
+			restored *k8sgarden.RestoredContainers
+			clock    *fakeclock.FakeClock
+			events   executor.EventSource
+			process  ifrit.Process
+		)
+
+		BeforeEach(func() {
+			addGardenContainer(newContainer("restored", executor.StateRunning), "active")
+			restored = restore()
+
+			hub = event.NewHub()
+			DeferCleanup(hub.Close)
+			var err error
+			events, err = hub.Subscribe()
+			Expect(err).NotTo(HaveOccurred())
+
+			clock = fakeclock.NewFakeClock(time.Now())
+			process = ifrit.Invoke(k8sgarden.NewContainerMonitor(logger, gardenClient, hub, restored, clock, time.Minute))
+			DeferCleanup(func() {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+			})
+		})
+
+		nextEvent := func() executor.Event {
+			ev, err := events.Next()
+			Expect(err).NotTo(HaveOccurred())
+			return ev
+		}
+
+		It("completes restored containers whose garden containers stopped", func() {
+			gardenContainers["restored"].InfoReturns(garden.ContainerInfo{State: "stopped"}, nil)
+			clock.WaitForWatcherAndIncrement(time.Minute)
+
+			ev := nextEvent()
+			Expect(ev).To(BeAssignableToTypeOf(executor.ContainerCompleteEvent{}))
+			completed := ev.(executor.ContainerCompleteEvent).Container()
+			Expect(completed.Guid).To(Equal("restored"))
+			Expect(completed.RunResult.Failed).To(BeTrue())
+			Expect(completed.RunResult.FailureReason).To(Equal("container stopped"))
+
+			container, ok := restored.Get("restored")
+			Expect(ok).To(BeTrue())
+			Expect(container.State).To(Equal(executor.StateCompleted))
+		})
+
+		It("completes restored containers whose garden containers are gone", func() {
+			removeGardenContainer("restored")
+			clock.WaitForWatcherAndIncrement(time.Minute)
+
+			ev := nextEvent()
+			Expect(ev.(executor.ContainerCompleteEvent).Container().RunResult.FailureReason).To(Equal("container not found"))
+		})
+
+		It("leaves restored containers that are still running alone", func() {
+			clock.WaitForWatcherAndIncrement(time.Minute)
+			clock.WaitForWatcherAndIncrement(time.Minute)
+
+			container, ok := restored.Get("restored")
+			Expect(ok).To(BeTrue())
+			Expect(container.State).To(Equal(executor.StateRunning))
+		})
+
+		It("records the state of containers that start running", func() {
+			gardenContainer := addGardenContainer(newContainer("new", executor.StateCreated), "active")
+			hub.Emit(executor.NewContainerRunningEvent(newContainer("new", executor.StateRunning), ""))
+
+			Eventually(gardenContainer.SetPropertyCallCount).Should(Equal(1))
+			name, value := gardenContainer.SetPropertyArgsForCall(0)
+			Expect(name).To(Equal(k8sgarden.ExecutorContainerProperty))
+			var container executor.Container
+			Expect(json.Unmarshal([]byte(value), &container)).To(Succeed())
+			Expect(container.State).To(Equal(executor.StateRunning))
+		})
+	})
+})
+
+// hostPortsClient is a garden client handing out host ports from a range with
+// the given number of free ports.
+type hostPortsClient struct {
+	*gardenfakes.FakeClient
+	free int
+}
+
+func (c *hostPortsClient) FreeHostPorts() (int, error) {
+	return c.free, nil
+}
@@ -1,10 +1,21 @@
 package k8sgarden_test
 
 import (
+	"context"
 	"errors"
 	"io"
+	"net"
+	"os"
+	"os/exec"
 	"strings"
-
+	"sync"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/commandrunner/fake_command_runner"
+	. "code.cloudfoundry.org/commandrunner/fake_command_runner/matchers"
+	"code.cloudfoundry.org/executor"
 	"code.cloudfoundry.org/garden"
 	"code.cloudfoundry.org/guardian/properties"
 	"code.cloudfoundry.org/guardian/rundmc/rundmcfakes"
@@ -12,12 +23,23 @@ import (
 	"code.cloudfoundry.org/guardian/rundmc/users/usersfakes"
 	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden"
 	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/containerd/containerdfakes"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/kubelet/kubeletfakes"
 	"code.cloudfoundry.org/lager/v3/lagertest"
 	ctrdclient "github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/pkg/cio"
+	"github.com/containerd/errdefs"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var _ = Describe("Container", func() {
@@ -31,12 +53,22 @@ var _ = Describe("Container", func() {
 		fakeAppTask       *containerdfakes.FakeTask
 		fakeSidecarTask   *containerdfakes.FakeTask
 		taskMap           map[string]ctrdclient.Task
+
+		fakeContainerdClient *containerdfakes.FakeClient
+		fakeKubeletClient    *kubeletfakes.FakeClient
+		k8sClient            ctrlclient.Client
+		fakeCmdRunner        *fake_command_runner.FakeCommandRunner
+		portManager          k8sgarden.PortManager
 	)
 
 	BeforeEach(func() {
 		logger = lagertest.NewTestLogger("container-test")
 		fakeNstarRunner = &rundmcfakes.FakeNstarRunner{}
 		fakeUserLookupper = &usersfakes.FakeUserLookupper{}
+		fakeContainerdClient = &containerdfakes.FakeClient{}
+		fakeKubeletClient = &kubeletfakes.FakeClient{}
+		fakeCmdRunner = fake_command_runner.New()
+		portManager = k8sgarden.NewPortManager(62000, 65535, nil, nil)
 		fakeAppTask = &containerdfakes.FakeTask{}
 		fakeAppTask.IDReturns("app-task")
 		fakeAppTask.PidReturns(12345)
@@ -45,11 +77,26 @@ var _ = Describe("Container", func() {
 		fakeSidecarTask.IDReturns("sidecar-task")
 		fakeSidecarTask.PidReturns(67890)
 
+		// processes started with Run keep running unless a test makes them exit
+		runningProcess := &containerdfakes.FakeProcess{}
+		runningProcess.WaitReturns(make(chan ctrdclient.ExitStatus), nil)
+		fakeAppTask.ExecReturns(runningProcess, nil)
+		fakeSidecarTask.ExecReturns(runningProcess, nil)
+
 		pod = &corev1.Pod{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: "test-container",
+				Name:      "test-container",
+				Namespace: "cf-workloads",
 			},
 			Spec: corev1.PodSpec{
+				Resources: &corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					},
+					Limits: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("256Mi"),
+					},
+				},
 				Containers: []corev1.Container{
 					{
 						Name: "app",
@@ -57,22 +104,30 @@ var _ = Describe("Container", func() {
 							{ContainerPort: 8080, HostPort: 30080},
 							{ContainerPort: 9090, HostPort: 30090},
 						},
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceEphemeralStorage: resource.MustParse("1G"),
+							},
+						},
 					},
 				},
 			},
 			Status: corev1.PodStatus{
-				HostIP: "10.0.0.1",
-				PodIP:  "10.244.0.1",
+				HostIP:    "10.0.0.1",
+				PodIP:     "10.244.0.1",
+				StartTime: ptr.To(metav1.NewTime(time.Now().Add(-time.Minute))),
 			},
 		}
 
+		k8sClient = fake.NewClientBuilder().WithObjects(pod.DeepCopy()).Build()
+
 		env = []string{"HOME=/home/vcap", "PATH=/usr/bin"}
 		taskMap = map[string]ctrdclient.Task{
 			"app":     fakeAppTask,
 			"sidecar": fakeSidecarTask,
 		}
 
-		testContainer = k8sgarden.NewContainer(logger, pod, env, 2.0, fakeNstarRunner, fakeUserLookupper, properties.NewManager(), 0, taskMap)
+		testContainer = k8sgarden.NewContainer(logger, pod, env, 2.0, fakeNstarRunner, fakeUserLookupper, properties.NewManager(), 0, taskMap, fakeContainerdClient, fakeKubeletClient, k8sClient, clock.NewClock(), k8sgarden.Config{}.Timeouts(), portManager, k8sgarden.NewPortForwarder(fakeCmdRunner))
 	})
 
 	Describe("Handle", func() {
@@ -92,6 +147,125 @@ var _ = Describe("Container", func() {
 			Expect(info.MappedPorts).To(HaveLen(2))
 			Expect(info.MappedPorts[0]).To(Equal(garden.PortMapping{HostPort: 30080, ContainerPort: 8080}))
 			Expect(info.MappedPorts[1]).To(Equal(garden.PortMapping{HostPort: 30090, ContainerPort: 9090}))
+			Expect(info.Events).To(BeEmpty())
+		})
+
+		Context("when the pod has a status", func() {
+			infoWithStatus := func(update func(status *corev1.PodStatus)) garden.ContainerInfo {
+				pod := pod.DeepCopy()
+				update(&pod.Status)
+
+				container := k8sgarden.NewContainer(logger, pod, env, 2.0, fakeNstarRunner, fakeUserLookupper, properties.NewManager(), 0, taskMap, fakeContainerdClient, fakeKubeletClient, k8sClient, clock.NewClock(), k8sgarden.Config{}.Timeouts(), portManager, k8sgarden.NewPortForwarder(fakeCmdRunner))
+				info, err := container.Info()
+				Expect(err).NotTo(HaveOccurred())
+				return info
+			}
+
+			It("is active while the app is running", func() {
+				info := infoWithStatus(func(status *corev1.PodStatus) {
+					status.Phase = corev1.PodRunning
+					status.ContainerStatuses = []corev1.ContainerStatus{
+						{Name: "app", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+					}
+				})
+				Expect(info.State).To(Equal("active"))
+				Expect(info.Events).To(BeEmpty())
+			})
+
+			It("reports an out of memory event when the app was OOM-killed", func() {
+				info := infoWithStatus(func(status *corev1.PodStatus) {
+					status.Phase = corev1.PodRunning
+					status.ContainerStatuses = []corev1.ContainerStatus{
+						{Name: "app", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}}},
+						{Name: "sidecar", LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}}},
+					}
+				})
+				Expect(info.State).To(Equal("stopped"))
+				Expect(info.Events).To(Equal([]string{k8sgarden.OutOfMemoryEvent}))
+			})
+
+			It("reports a disk limit event when the pod was evicted for its ephemeral storage", func() {
+				info := infoWithStatus(func(status *corev1.PodStatus) {
+					status.Phase = corev1.PodFailed
+					status.Reason = "Evicted"
+					status.Message = "Pod ephemeral local storage usage exceeds the total limit of containers 1G."
+				})
+				Expect(info.State).To(Equal("stopped"))
+				Expect(info.Events).To(Equal([]string{k8sgarden.DiskLimitExceededEvent}))
+			})
+
+			It("reports a disk limit event when the pod was evicted for an emptyDir volume", func() {
+				info := infoWithStatus(func(status *corev1.PodStatus) {
+					status.Phase = corev1.PodFailed
+					status.Reason = "Evicted"
+					status.Message = `Usage of EmptyDir volume "tmp" exceeds the limit "1Gi".`
+				})
+				Expect(info.Events).To(Equal([]string{k8sgarden.DiskLimitExceededEvent}))
+			})
+
+			It("reports no events for other evictions", func() {
+				info := infoWithStatus(func(status *corev1.PodStatus) {
+					status.Phase = corev1.PodFailed
+					status.Reason = "Evicted"
+					status.Message = "The node was low on resource: memory."
+				})
+				Expect(info.State).To(Equal("stopped"))
+				Expect(info.Events).To(BeEmpty())
+			})
+
+			It("is stopped when the app exited", func() {
+				info := infoWithStatus(func(status *corev1.PodStatus) {
+					status.Phase = corev1.PodSucceeded
+				})
+				Expect(info.State).To(Equal("stopped"))
+			})
+		})
+	})
+
+	Describe("Metrics", func() {
+		BeforeEach(func() {
+			fakeKubeletClient.GetMetricsReturns(map[string]executor.ContainerMetrics{
+				"test-container": {DiskUsageInBytes: 2048},
+			}, nil)
+		})
+
+		It("returns the containerd metrics of the app task as garden metrics", func() {
+			fakeContainerdClient.MetricsReturns(executor.ContainerMetrics{
+				MemoryUsageInBytes: 1024,
+				TimeSpentInCPU:     time.Second,
+				RxInBytes:          ptr.To[uint64](10),
+				TxInBytes:          ptr.To[uint64](20),
+			}, nil)
+
+			metrics, err := testContainer.Metrics()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeContainerdClient.MetricsCallCount()).To(Equal(1))
+			_, task := fakeContainerdClient.MetricsArgsForCall(0)
+			Expect(task).To(Equal(fakeAppTask))
+			Expect(fakeKubeletClient.GetMetricsCallCount()).To(Equal(1))
+			_, handles := fakeKubeletClient.GetMetricsArgsForCall(0)
+			Expect(handles).To(ConsistOf("test-container"))
+
+			Expect(metrics.MemoryStat.TotalUsageTowardLimit).To(Equal(uint64(1024)))
+			Expect(metrics.DiskStat.TotalBytesUsed).To(Equal(uint64(2048)))
+			Expect(metrics.CPUStat.Usage).To(Equal(uint64(time.Second)))
+			Expect(metrics.NetworkStat).To(Equal(&garden.ContainerNetworkStat{RxBytes: 10, TxBytes: 20}))
+			Expect(metrics.Age).To(BeNumerically("~", time.Minute, 5*time.Second))
+			Expect(metrics.CPUEntitlement).To(Equal(uint64(2.0 * float64(metrics.Age))))
+		})
+
+		It("returns an error when containerd fails to report metrics", func() {
+			fakeContainerdClient.MetricsReturns(executor.ContainerMetrics{}, errors.New("cgroup gone"))
+
+			_, err := testContainer.Metrics()
+			Expect(err).To(MatchError(ContainSubstring("cgroup gone")))
+		})
+
+		It("returns an error when kubelet fails to report the ephemeral storage", func() {
+			fakeKubeletClient.GetMetricsReturns(nil, errors.New("kubelet unavailable"))
+
+			_, err := testContainer.Metrics()
+			Expect(err).To(MatchError(ContainSubstring("kubelet unavailable")))
 		})
 	})
 
@@ -129,6 +303,39 @@ var _ = Describe("Container", func() {
 			Expect(process.Task().ID()).To(Equal("app-task"))
 		})
 
+		It("runs the process with a terminal when a TTY is requested", func() {
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 2000, Home: "/home/vcap"}, nil)
+
+			proc, err := testContainer.Run(garden.ProcessSpec{
+				ID:   "process-tty",
+				Path: "/bin/bash",
+				User: "vcap",
+				TTY: &garden.TTYSpec{
+					WindowSize: &garden.WindowSize{Columns: 100, Rows: 30},
+				},
+			}, garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+
+			process := proc.(k8sgarden.Process)
+			Expect(process.Spec().Terminal).To(BeTrue())
+			Expect(process.Spec().ConsoleSize).To(Equal(&specs.Box{Width: 100, Height: 30}))
+		})
+
+		It("runs the process without a terminal by default", func() {
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 2000, Home: "/home/vcap"}, nil)
+
+			proc, err := testContainer.Run(garden.ProcessSpec{
+				ID:   "process-no-tty",
+				Path: "/bin/bash",
+				User: "vcap",
+			}, garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+
+			process := proc.(k8sgarden.Process)
+			Expect(process.Spec().Terminal).To(BeFalse())
+			Expect(process.Spec().ConsoleSize).To(BeNil())
+		})
+
 		It("runs process in sidecar container when image URI is specified", func() {
 			fakeUserLookupper.LookupReturns(&users.ExecUser{
 				Uid:  1000,
@@ -196,6 +403,42 @@ var _ = Describe("Container", func() {
 			Expect(proc.ID()).NotTo(BeEmpty())
 		})
 
+		It("rejects process IDs that are already in use", func() {
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 1000, Home: "/home/vcap"}, nil)
+
+			_, err := testContainer.Run(garden.ProcessSpec{ID: "duplicate", Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = testContainer.Run(garden.ProcessSpec{ID: "duplicate", Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).To(MatchError("process ID 'duplicate' already in use"))
+		})
+
+		It("starts the process before returning", func() {
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 1000, Home: "/home/vcap"}, nil)
+			fakeProcess := &containerdfakes.FakeProcess{}
+			fakeProcess.WaitReturns(make(chan ctrdclient.ExitStatus), nil)
+			fakeAppTask.ExecReturns(fakeProcess, nil)
+
+			proc, err := testContainer.Run(garden.ProcessSpec{ID: "started", Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeAppTask.ExecCallCount()).To(Equal(1))
+			Expect(fakeProcess.StartCallCount()).To(Equal(1))
+
+			Expect(proc.Signal(garden.SignalKill)).To(Succeed())
+			Expect(fakeProcess.KillCallCount()).To(Equal(1))
+		})
+
+		It("releases the process ID when the process fails to start", func() {
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 1000, Home: "/home/vcap"}, nil)
+			fakeAppTask.ExecReturnsOnCall(0, nil, errors.New("exec failed"))
+
+			_, err := testContainer.Run(garden.ProcessSpec{ID: "failing", Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).To(MatchError(ContainSubstring("exec failed")))
+
+			_, err = testContainer.Run(garden.ProcessSpec{ID: "failing", Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
 		It("handles errors from user lookup, StreamIn and StreamOut", func() {
 			// Test Run error when user lookup fails
 			fakeUserLookupper.LookupReturns(nil, errors.New("user not found"))
@@ -208,6 +451,403 @@ var _ = Describe("Container", func() {
 		})
 	})
 
+	Describe("Attach", func() {
+		var (
+			fakeProcess *containerdfakes.FakeProcess
+			exitChan    chan ctrdclient.ExitStatus
+		)
+
+		BeforeEach(func() {
+			exitChan = make(chan ctrdclient.ExitStatus, 1)
+			fakeProcess = &containerdfakes.FakeProcess{}
+			fakeProcess.WaitReturns(exitChan, nil)
+			fakeAppTask.LoadProcessReturns(nil, errdefs.ErrNotFound)
+			fakeSidecarTask.LoadProcessReturns(nil, errdefs.ErrNotFound)
+		})
+
+		It("re-opens the IO of a process that is still running in the task", func() {
+			fakeSidecarTask.LoadProcessReturns(fakeProcess, nil)
+
+			stdout := gbytes.NewBuffer()
+			proc, err := testContainer.Attach("running-process", garden.ProcessIO{Stdout: stdout})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proc.ID()).To(Equal("running-process"))
+
+			Expect(fakeSidecarTask.LoadProcessCallCount()).To(Equal(1))
+			_, id, attach := fakeSidecarTask.LoadProcessArgsForCall(0)
+			Expect(id).To(Equal("running-process"))
+			Expect(attach).NotTo(BeNil())
+
+			Expect(proc.Signal(garden.SignalTerminate)).To(Succeed())
+			Expect(fakeProcess.KillCallCount()).To(Equal(1))
+
+			exitChan <- *ctrdclient.NewExitStatus(0, time.Now(), nil)
+			exitCode, err := proc.Wait()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exitCode).To(Equal(0))
+			Expect(fakeSidecarTask.ExecCallCount()).To(Equal(0))
+		})
+
+		It("writes the output of an attached process to every later attach without re-opening its fifos", func() {
+			fifos, err := cio.NewFIFOSetInDir(GinkgoT().TempDir(), "running-process", false)
+			Expect(err).NotTo(HaveOccurred())
+			fakeAppTask.LoadProcessStub = func(_ context.Context, _ string, attach cio.Attach) (ctrdclient.Process, error) {
+				if _, err := attach(fifos); err != nil {
+					return nil, err
+				}
+				return fakeProcess, nil
+			}
+
+			firstStdout := gbytes.NewBuffer()
+			first, err := testContainer.Attach("running-process", garden.ProcessIO{Stdout: firstStdout})
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := os.OpenFile(fifos.Stdout, os.O_WRONLY, 0)
+			Expect(err).NotTo(HaveOccurred())
+			defer output.Close()
+
+			_, err = output.WriteString("before\n")
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(firstStdout).Should(gbytes.Say("before"))
+
+			secondStdout := gbytes.NewBuffer()
+			second, err := testContainer.Attach("running-process", garden.ProcessIO{Stdout: secondStdout})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(second).To(BeIdenticalTo(first))
+			Expect(fakeAppTask.LoadProcessCallCount()).To(Equal(1))
+
+			_, err = output.WriteString("after\n")
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(firstStdout).Should(gbytes.Say("after"))
+			Eventually(secondStdout).Should(gbytes.Say("after"))
+			Expect(secondStdout.Contents()).NotTo(ContainSubstring("before"))
+		})
+
+		It("attaches to processes started with Run without loading them again", func() {
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 1000, Home: "/home/vcap"}, nil)
+
+			proc, err := testContainer.Run(garden.ProcessSpec{ID: "started-process", Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+
+			attached, err := testContainer.Attach("started-process", garden.ProcessIO{Stdout: gbytes.NewBuffer()})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attached).To(BeIdenticalTo(proc))
+			Expect(fakeAppTask.LoadProcessCallCount()).To(Equal(0))
+		})
+
+		It("tracks attached processes so their IDs cannot be reused", func() {
+			fakeAppTask.LoadProcessReturns(fakeProcess, nil)
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 1000, Home: "/home/vcap"}, nil)
+
+			_, err := testContainer.Attach("running-process", garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = testContainer.Run(garden.ProcessSpec{ID: "running-process", Path: "/bin/sh", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).To(MatchError("process ID 'running-process' already in use"))
+		})
+
+		It("returns a process not found error for unknown processes", func() {
+			_, err := testContainer.Attach("unknown-process", garden.ProcessIO{})
+			Expect(err).To(MatchError(garden.ProcessNotFoundError{ProcessID: "unknown-process"}))
+		})
+
+		It("returns an error when loading the process fails", func() {
+			fakeAppTask.LoadProcessReturns(nil, errors.New("containerd unavailable"))
+
+			_, err := testContainer.Attach("running-process", garden.ProcessIO{})
+			Expect(err).To(MatchError(ContainSubstring("containerd unavailable")))
+		})
+	})
+
+	Describe("Current limits", func() {
+		It("returns the memory limit of the pod", func() {
+			limits, err := testContainer.CurrentMemoryLimits()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits).To(Equal(garden.MemoryLimits{LimitInBytes: 256 * 1024 * 1024}))
+		})
+
+		It("returns the CPU weight of the CPU assignment", func() {
+			limits, err := testContainer.CurrentCPULimits()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits).To(Equal(garden.CPULimits{Weight: 2048}))
+		})
+
+		It("returns the ephemeral storage limit of the app container", func() {
+			limits, err := testContainer.CurrentDiskLimits()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits).To(Equal(garden.DiskLimits{ByteHard: 1000 * 1000 * 1000, Scope: garden.DiskLimitScopeTotal}))
+		})
+
+		It("returns no bandwidth limits without shaping annotations", func() {
+			limits, err := testContainer.CurrentBandwidthLimits()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits).To(BeZero())
+		})
+
+		Context("when the pod has shaping annotations", func() {
+			BeforeEach(func() {
+				pod.Annotations = map[string]string{
+					k8sgarden.IngressBandwidthAnnotationKey: "8M",
+					k8sgarden.EgressBandwidthAnnotationKey:  "16M",
+				}
+			})
+
+			It("returns the ingress rate in bytes", func() {
+				limits, err := testContainer.CurrentBandwidthLimits()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(limits.RateInBytesPerSecond).To(Equal(uint64(1000 * 1000)))
+			})
+		})
+
+		Context("when the shaping annotation is invalid", func() {
+			BeforeEach(func() {
+				pod.Annotations = map[string]string{
+					k8sgarden.IngressBandwidthAnnotationKey: "fast",
+				}
+			})
+
+			It("returns an error", func() {
+				_, err := testContainer.CurrentBandwidthLimits()
+				Expect(err).To(MatchError(ContainSubstring("failed to parse annotation kubernetes.io/ingress-bandwidth")))
+			})
+		})
+	})
+
+	Describe("NetIn", func() {
+		dnatCommand := func(action, hostPort, destination string) fake_command_runner.CommandSpec {
+			return fake_command_runner.CommandSpec{
+				Path: "nsenter",
+				Args: []string{
+					"--net=/proc/1/ns/net", "--", "iptables", "-w", "-t", "nat", action, "K8S-GARDEN-NETIN",
+					"-p", "tcp", "--dport", hostPort, "-m", "comment", "--comment", "test-container",
+					"-j", "DNAT", "--to-destination", destination,
+				},
+			}
+		}
+
+		It("allocates a host port and forwards it to the pod", func() {
+			hostPort, containerPort, err := testContainer.NetIn(0, 8081)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hostPort).To(Equal(uint32(62000)))
+			Expect(containerPort).To(Equal(uint32(8081)))
+
+			Expect(fakeCmdRunner).To(HaveExecutedSerially(dnatCommand("-A", "62000", "10.244.0.1:8081")))
+		})
+
+		It("uses the host port as container port if none is given", func() {
+			hostPort, containerPort, err := testContainer.NetIn(61001, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hostPort).To(Equal(uint32(61001)))
+			Expect(containerPort).To(Equal(uint32(61001)))
+		})
+
+		It("reports the mapping in the info and records it on the pod", func() {
+			_, _, err := testContainer.NetIn(0, 8081)
+			Expect(err).NotTo(HaveOccurred())
+			_, _, err = testContainer.NetIn(0, 8082)
+			Expect(err).NotTo(HaveOccurred())
+
+			info, err := testContainer.Info()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.MappedPorts).To(ConsistOf(
+				garden.PortMapping{HostPort: 30080, ContainerPort: 8080},
+				garden.PortMapping{HostPort: 30090, ContainerPort: 9090},
+				garden.PortMapping{HostPort: 62000, ContainerPort: 8081},
+				garden.PortMapping{HostPort: 62001, ContainerPort: 8082},
+			))
+
+			updatedPod := &corev1.Pod{}
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKeyFromObject(pod), updatedPod)).To(Succeed())
+			Expect(updatedPod.Annotations).To(HaveKeyWithValue(k8sgarden.NetInAnnotationKey, `[{"HostPort":62000,"ContainerPort":8081},{"HostPort":62001,"ContainerPort":8082}]`))
+		})
+
+		It("releases the host port when the rule can not be added", func() {
+			fakeCmdRunner.WhenRunning(dnatCommand("-A", "62000", "10.244.0.1:8081"), func(*exec.Cmd) error {
+				return errors.New("iptables failed")
+			})
+
+			_, _, err := testContainer.NetIn(0, 8081)
+			Expect(err).To(MatchError(ContainSubstring("iptables failed")))
+
+			port, err := portManager.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(port).To(Equal(uint32(62000)))
+
+			info, err := testContainer.Info()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.MappedPorts).To(HaveLen(2))
+		})
+
+		It("reserves explicit host ports, so that they are not handed out again", func() {
+			_, _, err := testContainer.NetIn(62000, 8081)
+			Expect(err).NotTo(HaveOccurred())
+
+			port, err := portManager.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(port).To(Equal(uint32(62001)))
+		})
+
+		It("fails for explicit host ports in use without releasing them", func() {
+			port, err := portManager.Next()
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, err = testContainer.NetIn(port, 8081)
+			Expect(err).To(MatchError(k8sgarden.PortInUseError{Port: port}))
+			Expect(fakeCmdRunner.ExecutedCommands()).To(BeEmpty())
+
+			Expect(portManager.Acquire(port)).To(MatchError(k8sgarden.PortInUseError{Port: port}))
+		})
+	})
+
+	Describe("NetOut", func() {
+		getPolicy := func() *networkingv1.NetworkPolicy {
+			policy := &networkingv1.NetworkPolicy{}
+			Expect(k8sClient.Get(context.Background(), ctrlclient.ObjectKey{Name: "test-container", Namespace: "cf-workloads"}, policy)).To(Succeed())
+			return policy
+		}
+
+		It("creates an egress network policy selecting the pod", func() {
+			Expect(testContainer.NetOut(garden.NetOutRule{
+				Protocol: garden.ProtocolTCP,
+				Networks: []garden.IPRange{{Start: net.ParseIP("10.0.0.0"), End: net.ParseIP("10.0.1.255")}},
+				Ports:    []garden.PortRange{{Start: 8080, End: 8090}, garden.PortRangeFromPort(443)},
+			})).To(Succeed())
+
+			policy := getPolicy()
+			Expect(policy.Spec.PodSelector.MatchLabels).To(Equal(map[string]string{k8sgarden.HandleLabelKey: "test-container"}))
+			Expect(policy.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeEgress))
+			Expect(policy.Spec.Egress).To(HaveLen(2))
+			Expect(policy.Spec.Egress[1].To).To(ConsistOf(networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/23"}}))
+			Expect(policy.Spec.Egress[1].Ports).To(ConsistOf(
+				networkingv1.NetworkPolicyPort{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt32(8080)), EndPort: ptr.To[int32](8090)},
+				networkingv1.NetworkPolicyPort{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt32(443))},
+			))
+			Expect(policy.Annotations).NotTo(HaveKey(k8sgarden.NetOutLogAnnotationKey))
+		})
+
+		It("appends rules to an existing policy", func() {
+			Expect(testContainer.NetOut(garden.NetOutRule{
+				Protocol: garden.ProtocolUDP,
+				Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP("8.8.8.8"))},
+			})).To(Succeed())
+
+			Expect(testContainer.BulkNetOut([]garden.NetOutRule{
+				{Protocol: garden.ProtocolAll, Networks: []garden.IPRange{{Start: net.ParseIP("192.168.0.1"), End: net.ParseIP("192.168.0.6")}}, Log: true},
+				{Protocol: garden.ProtocolICMP, ICMPs: &garden.ICMPControl{Type: 8}},
+			})).To(Succeed())
+
+			policy := getPolicy()
+			Expect(policy.Spec.Egress).To(HaveLen(3))
+			Expect(policy.Spec.Egress[1].To).To(ConsistOf(networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: "8.8.8.8/32"}}))
+			Expect(policy.Spec.Egress[1].Ports).To(ConsistOf(networkingv1.NetworkPolicyPort{Protocol: ptr.To(corev1.ProtocolUDP)}))
+
+			var cidrs []string
+			for _, peer := range policy.Spec.Egress[2].To {
+				cidrs = append(cidrs, peer.IPBlock.CIDR)
+			}
+			Expect(cidrs).To(Equal([]string{"192.168.0.1/32", "192.168.0.2/31", "192.168.0.4/31", "192.168.0.6/32"}))
+			Expect(policy.Spec.Egress[2].Ports).To(BeEmpty())
+			Expect(policy.Annotations).To(HaveKeyWithValue(k8sgarden.NetOutLogAnnotationKey, "true"))
+		})
+
+		It("allows all destinations for rules without networks", func() {
+			Expect(testContainer.NetOut(garden.NetOutRule{
+				Protocol: garden.ProtocolTCP,
+				Networks: []garden.IPRange{{}},
+			})).To(Succeed())
+
+			Expect(getPolicy().Spec.Egress[1].To).To(ConsistOf(networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0"}}))
+		})
+
+		It("returns an error for invalid ranges", func() {
+			err := testContainer.NetOut(garden.NetOutRule{
+				Networks: []garden.IPRange{{Start: net.ParseIP("10.0.0.2"), End: net.ParseIP("10.0.0.1")}},
+			})
+			Expect(err).To(MatchError(ContainSubstring("invalid address range")))
+		})
+	})
+
+	Describe("Stop", func() {
+		var (
+			fakeProcess *containerdfakes.FakeProcess
+			exitChan    chan ctrdclient.ExitStatus
+			statusLock  sync.Mutex
+			status      ctrdclient.ProcessStatus
+			exitOn      syscall.Signal
+		)
+
+		BeforeEach(func() {
+			exitChan = make(chan ctrdclient.ExitStatus, 1)
+			status = ctrdclient.Running
+			exitOn = syscall.SIGTERM
+
+			fakeProcess = &containerdfakes.FakeProcess{}
+			fakeProcess.WaitReturns(exitChan, nil)
+			fakeProcess.StatusStub = func(context.Context) (ctrdclient.Status, error) {
+				statusLock.Lock()
+				defer statusLock.Unlock()
+				return ctrdclient.Status{Status: status}, nil
+			}
+			fakeProcess.KillStub = func(_ context.Context, signal syscall.Signal, _ ...ctrdclient.KillOpts) error {
+				if signal == exitOn || signal == syscall.SIGKILL {
+					statusLock.Lock()
+					defer statusLock.Unlock()
+					if status != ctrdclient.Stopped {
+						status = ctrdclient.Stopped
+						exitChan <- *ctrdclient.NewExitStatus(143, time.Now(), nil)
+					}
+				}
+				return nil
+			}
+			fakeAppTask.ExecReturns(fakeProcess, nil)
+			fakeUserLookupper.LookupReturns(&users.ExecUser{Uid: 1000, Gid: 1000, Home: "/home/vcap"}, nil)
+
+			proc, err := testContainer.Run(garden.ProcessSpec{ID: "app-process", Path: "/bin/sleep", User: "vcap"}, garden.ProcessIO{})
+			Expect(err).NotTo(HaveOccurred())
+			go func() {
+				defer GinkgoRecover()
+				_, _ = proc.Wait()
+			}()
+			Eventually(fakeProcess.StartCallCount).Should(Equal(1))
+		})
+
+		It("terminates the processes and marks the container as stopped", func() {
+			Expect(testContainer.Stop(false)).To(Succeed())
+
+			Expect(fakeProcess.KillCallCount()).To(Equal(1))
+			_, signal, _ := fakeProcess.KillArgsForCall(0)
+			Expect(signal).To(Equal(syscall.SIGTERM))
+
+			info, err := testContainer.Info()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.State).To(Equal("stopped"))
+			Expect(testContainer.Property("garden.state")).To(Equal("stopped"))
+		})
+
+		It("kills the processes right away when kill is set", func() {
+			Expect(testContainer.Stop(true)).To(Succeed())
+
+			Expect(fakeProcess.KillCallCount()).To(Equal(1))
+			_, signal, _ := fakeProcess.KillArgsForCall(0)
+			Expect(signal).To(Equal(syscall.SIGKILL))
+		})
+
+		It("kills processes that are still running after the grace time", func() {
+			exitOn = syscall.SIGKILL
+			Expect(testContainer.SetGraceTime(200 * time.Millisecond)).To(Succeed())
+
+			start := time.Now()
+			Expect(testContainer.Stop(false)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+
+			Expect(fakeProcess.KillCallCount()).To(Equal(2))
+			_, signal, _ := fakeProcess.KillArgsForCall(0)
+			Expect(signal).To(Equal(syscall.SIGTERM))
+			_, signal, _ = fakeProcess.KillArgsForCall(1)
+			Expect(signal).To(Equal(syscall.SIGKILL))
+		})
+	})
+
 	Describe("StreamIn", func() {
 		It("streams data into the container using nstar", func() {
 			tarStream := io.NopCloser(strings.NewReader("tar-data"))
@@ -1,42 +1,131 @@
 package containerd
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"code.cloudfoundry.org/executor"
+	cgroup1stats "github.com/containerd/cgroups/v3/cgroup1/stats"
+	cgroup2stats "github.com/containerd/cgroups/v3/cgroup2/stats"
+	"github.com/containerd/containerd/api/types"
 	ctrdclient "github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/images"
 	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/remotes"
 	"github.com/containerd/containerd/v2/core/remotes/docker"
+	dockerconfig "github.com/containerd/containerd/v2/core/remotes/docker/config"
 	"github.com/containerd/continuity/fs"
+	"github.com/containerd/typeurl/v2"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/singleflight"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/utils/ptr"
-	"github.com/distribution/reference"
 )
 
+// networkInterface is the pod interface whose counters are reported, matching
+// the interface kubelet uses for the pod network stats in /stats/summary.
+const networkInterface = "eth0"
+
 //go:generate go tool counterfeiter -generate
 
 //counterfeiter:generate github.com/containerd/containerd/v2/client.Task
 //counterfeiter:generate github.com/containerd/containerd/v2/client.Process
 //counterfeiter:generate github.com/containerd/containerd/v2/client.Image
+//counterfeiter:generate github.com/containerd/containerd/v2/core/images.Store
 
 //counterfeiter:generate . Client
 type Client interface {
 	IsServing(ctx context.Context) (bool, error)
 	LoadTasks(ctx context.Context, statuses []corev1.ContainerStatus) (map[string]ctrdclient.Task, error)
-	Pull(ctx context.Context, ref, username, password string) (ctrdclient.Image, int64, error)
-	Delete(ctx context.Context, img ctrdclient.Image) error
+	// Pull pulls the image unless the same image is being pulled already,
+	// bounded by timeout, and returns the size of its rootfs.
+	Pull(ctx context.Context, ref, username, password string, timeout time.Duration) (ctrdclient.Image, int64, error)
+	// Delete deletes the image of the given name, unless it was pulled again
+	// with another digest in the meantime. Its content is removed before
+	// Delete returns.
+	Delete(ctx context.Context, name string, target digest.Digest) error
+	// ImageStoreUsage returns the size of the content of all images in bytes.
+	ImageStoreUsage(ctx context.Context) (int64, error)
+	// Metrics returns the resource usage of the task, read from its cgroup
+	// and its network namespace. The disk usage is left to kubelet, which
+	// knows the volumes and logs of the pod as well.
+	Metrics(ctx context.Context, task ctrdclient.Task) (executor.ContainerMetrics, error)
+}
+
+const (
+	// rootfsChainIDLabel and rootfsSizeLabel record the size of the unpacked
+	// rootfs on the image, so that it is not computed again after a restart.
+	// The size is only valid for the rootfs of the chain ID, since pulling the
+	// image again may update it.
+	rootfsChainIDLabel = "cloudfoundry.org/rootfs-chain-id"
+	rootfsSizeLabel    = "cloudfoundry.org/rootfs-size"
+)
+
+// RegistryConfig configures the registries images are pulled from.
+type RegistryConfig struct {
+	// HostsDir is a directory in the hosts.toml layout of containerd, e.g.
+	// /etc/containerd/certs.d, with the mirrors, plain HTTP endpoints, CAs and
+	// client certificates of each registry. Like in containerd, registries
+	// without a hosts.toml, or with one that can not be parsed, are pulled
+	// from directly.
+	HostsDir string
+	// RootCAs are the CAs trusted by all registries. The system CAs are used
+	// if it is nil.
+	RootCAs *x509.CertPool
+	// Credentials returns the username and password of a registry host for
+	// pulls without credentials of their own. Images are pulled anonymously
+	// if it is nil or returns empty credentials.
+	Credentials func(host string) (string, string, error)
 }
 
 type clientWrapper struct {
-	client *ctrdclient.Client
+	client     *ctrdclient.Client
+	registries RegistryConfig
+
+	// imageStore, pullImage and diskUsage are the parts of pulling that
+	// need containerd.
+	imageStore images.Store
+	pullImage  func(ctx context.Context, ref string, opts ...ctrdclient.RemoteOpt) (ctrdclient.Image, error)
+	diskUsage  func(ctx context.Context, chainID digest.Digest) (int64, error)
+
+	// pulls collapses concurrent pulls of the same reference.
+	pulls singleflight.Group
+
+	// rootfsSizes are the sizes of the rootfs of the pulled images by the
+	// digest of the image.
+	mu          sync.Mutex
+	rootfsSizes map[digest.Digest]int64
 }
 
-func NewClientWrapper(client *ctrdclient.Client) Client {
-	return &clientWrapper{client: client}
+func NewClientWrapper(client *ctrdclient.Client, registries RegistryConfig) Client {
+	w := newClientWrapper(registries, client.ImageService(), client.Pull, nil)
+	w.client = client
+	w.diskUsage = w.snapshotDiskUsage
+	return w
+}
+
+func newClientWrapper(registries RegistryConfig, imageStore images.Store, pullImage func(context.Context, string, ...ctrdclient.RemoteOpt) (ctrdclient.Image, error), diskUsage func(context.Context, digest.Digest) (int64, error)) *clientWrapper {
+	return &clientWrapper{
+		registries:  registries,
+		imageStore:  imageStore,
+		pullImage:   pullImage,
+		diskUsage:   diskUsage,
+		rootfsSizes: map[digest.Digest]int64{},
+	}
 }
 
 func (w *clientWrapper) IsServing(ctx context.Context) (bool, error) {
@@ -61,45 +150,172 @@ func (w *clientWrapper) LoadTasks(ctx context.Context, statuses []corev1.Contain
 	return taskMap, nil
 }
 
-func (w *clientWrapper) Pull(ctx context.Context, ref, username, password string) (ctrdclient.Image, int64, error) {
+type pullResult struct {
+	img  ctrdclient.Image
+	size int64
+}
+
+// Pull pulls and unpacks the image and returns the size of its rootfs.
+// Concurrent pulls of the same reference with the same credentials share one
+// pull. The shared pull is not cancelled with the context of any caller, it
+// ends once the timeout of the first caller has passed, so that a registry
+// that does not answer can not keep later pulls waiting. Callers stop waiting
+// for it when their own context is done.
+func (w *clientWrapper) Pull(ctx context.Context, ref, username, password string, timeout time.Duration) (ctrdclient.Image, int64, error) {
 	normalizedRef, err := reference.ParseNormalizedNamed(ref)
 
 	if err != nil {
 		return nil, 0, err
 	}
 
+	results := w.pulls.DoChan(pullKey(normalizedRef, username, password), func() (any, error) {
+		pullCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+
+		img, size, err := w.pull(pullCtx, normalizedRef, username, password)
+		return pullResult{img: img, size: size}, err
+	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, 0, result.Err
+		}
+
+		pulled := result.Val.(pullResult)
+		return pulled.img, pulled.size, nil
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+// pullKey identifies pulls that can be shared. The credentials are hashed to
+// not keep them in memory for longer than the pull.
+func pullKey(ref reference.Named, username, password string) string {
+	credentials := sha256.Sum256([]byte(username + "\x00" + password))
+	return ref.String() + "@" + hex.EncodeToString(credentials[:])
+}
+
+// resolver returns the resolver of the registries, with the credentials for
+// the registry of the image if any, falling back to the credentials of the
+// registry config.
+func (w *clientWrapper) resolver(ctx context.Context, username, password string) remotes.Resolver {
+	options := dockerconfig.HostOptions{}
+
+	if w.registries.HostsDir != "" {
+		options.HostDir = dockerconfig.HostDirFromRoot(w.registries.HostsDir)
+	}
+
+	if username != "" && password != "" {
+		options.Credentials = func(string) (string, string, error) {
+			return username, password, nil
+		}
+	} else if w.registries.Credentials != nil {
+		options.Credentials = w.registries.Credentials
+	}
+
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts: func(host string) ([]docker.RegistryHost, error) {
+			// The CAs of hosts.toml are added to the root CAs, which must
+			// not be shared with other registries.
+			options := options
+			options.DefaultTLS = &tls.Config{
+				RootCAs:    w.rootCAs(),
+				MinVersion: tls.VersionTLS12,
+			}
+			return dockerconfig.ConfigureHosts(ctx, options)(host)
+		},
+	})
+}
+
+// rootCAs returns a copy of the root CAs of the registry config, or nil for
+// the system CAs.
+func (w *clientWrapper) rootCAs() *x509.CertPool {
+	if w.registries.RootCAs == nil {
+		return nil
+	}
+	return w.registries.RootCAs.Clone()
+}
+
+func (w *clientWrapper) pull(ctx context.Context, normalizedRef reference.Named, username, password string) (ctrdclient.Image, int64, error) {
 	opts := []ctrdclient.RemoteOpt{
 		ctrdclient.WithPullUnpack,
+		ctrdclient.WithResolver(w.resolver(ctx, username, password)),
 	}
 
-	if username != "" && password != "" {
-		opts = append(opts, ctrdclient.WithResolver(docker.NewResolver(docker.ResolverOptions{
-			Hosts: docker.ConfigureDefaultRegistries(docker.WithAuthorizer(
-				docker.NewDockerAuthorizer(docker.WithAuthCreds(
-					func(s string) (string, string, error) {
-						return username, password, nil
-					},
-				)),
-			)),
-		})))
-	}
-
-	img, err := w.client.Pull(ctx, normalizedRef.String(), opts...)
+	img, err := w.pullImage(ctx, normalizedRef.String(), opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	totalSize, err := w.rootfsSize(ctx, img)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	return img, totalSize, nil
+}
+
+// rootfsSize returns the size of the unpacked rootfs of the image. It is
+// cached in memory until the image is deleted, and on the image for its chain
+// ID.
+func (w *clientWrapper) rootfsSize(ctx context.Context, img ctrdclient.Image) (int64, error) {
+	target := img.Target().Digest
+
+	w.mu.Lock()
+	size, ok := w.rootfsSizes[target]
+	w.mu.Unlock()
+	if ok {
+		return size, nil
+	}
+
 	diffIDs, err := img.RootFS(ctx)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get rootfs: %w", err)
+		return 0, fmt.Errorf("failed to get rootfs: %w", err)
+	}
+	chainID := identity.ChainID(diffIDs)
+
+	labels := img.Labels()
+	if labels[rootfsChainIDLabel] == chainID.String() {
+		if size, err := strconv.ParseInt(labels[rootfsSizeLabel], 10, 64); err == nil {
+			w.cacheRootfsSize(target, size)
+			return size, nil
+		}
+	}
+
+	size, err = w.diskUsage(ctx, chainID)
+	if err != nil {
+		return 0, err
 	}
+	w.cacheRootfsSize(target, size)
+
+	// the size is computed again after a restart if the labels can not be set
+	_, _ = w.imageStore.Update(ctx, images.Image{
+		Name: img.Name(),
+		Labels: map[string]string{
+			rootfsChainIDLabel: chainID.String(),
+			rootfsSizeLabel:    strconv.FormatInt(size, 10),
+		},
+	}, "labels."+rootfsChainIDLabel, "labels."+rootfsSizeLabel)
 
+	return size, nil
+}
+
+func (w *clientWrapper) cacheRootfsSize(target digest.Digest, size int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.rootfsSizes[target] = size
+}
+
+// snapshotDiskUsage mounts a view of the snapshot of the chain ID and walks
+// it to compute its size.
+func (w *clientWrapper) snapshotDiskUsage(ctx context.Context, chainID digest.Digest) (int64, error) {
 	snapshotter := w.client.SnapshotService("")
-	finalChainID := identity.ChainID(diffIDs).String()
 	viewKey := fmt.Sprintf("temp-view-%d", time.Now().UnixNano())
-	mounts, err := snapshotter.View(ctx, viewKey, finalChainID) // .View always returns read-only mounts
+	mounts, err := snapshotter.View(ctx, viewKey, chainID.String()) // .View always returns read-only mounts
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create view snapshot: %w", err)
+		return 0, fmt.Errorf("failed to create view snapshot: %w", err)
 	}
 
 	defer func() {
@@ -116,12 +332,132 @@ func (w *clientWrapper) Pull(ctx context.Context, ref, username, password string
 		totalSize = usage.Size
 		return nil
 	}); err != nil {
-		return nil, 0, fmt.Errorf("failed to calculate mounted size: %w", err)
+		return 0, fmt.Errorf("failed to calculate mounted size: %w", err)
 	}
 
-	return img, totalSize, nil
+	return totalSize, nil
+}
+
+func (w *clientWrapper) Delete(ctx context.Context, name string, target digest.Digest) error {
+	if err := w.imageStore.Delete(ctx, name, images.DeleteTarget(&ocispec.Descriptor{Digest: target}), images.SynchronousDelete()); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	delete(w.rootfsSizes, target)
+	w.mu.Unlock()
+
+	return nil
+}
+
+func (w *clientWrapper) ImageStoreUsage(ctx context.Context) (int64, error) {
+	var usage int64
+	if err := w.client.ContentStore().Walk(ctx, func(info content.Info) error {
+		usage += info.Size
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to walk content store: %w", err)
+	}
+
+	return usage, nil
+}
+
+func (w *clientWrapper) Metrics(ctx context.Context, task ctrdclient.Task) (executor.ContainerMetrics, error) {
+	metric, err := task.Metrics(ctx)
+	if err != nil {
+		return executor.ContainerMetrics{}, fmt.Errorf("failed to get task metrics: %w", err)
+	}
+
+	metrics, err := cgroupMetrics(metric)
+	if err != nil {
+		return executor.ContainerMetrics{}, err
+	}
+
+	rx, tx, err := networkStats(task.Pid())
+	if err != nil {
+		return executor.ContainerMetrics{}, fmt.Errorf("failed to get network stats: %w", err)
+	}
+	metrics.RxInBytes = rx
+	metrics.TxInBytes = tx
+
+	return metrics, nil
 }
 
-func (w *clientWrapper) Delete(ctx context.Context, img ctrdclient.Image) error {
-	return w.client.ImageService().Delete(ctx, img.Name(), images.DeleteTarget(ptr.To(img.Target())))
+// cgroupMetrics converts the cgroup v1 or v2 stats of a task into container
+// metrics. Memory is reported as working set, the same value kubelet reports.
+func cgroupMetrics(metric *types.Metric) (executor.ContainerMetrics, error) {
+	switch {
+	case typeurl.Is(metric.Data, (*cgroup1stats.Metrics)(nil)):
+		data := &cgroup1stats.Metrics{}
+		if err := typeurl.UnmarshalTo(metric.Data, data); err != nil {
+			return executor.ContainerMetrics{}, fmt.Errorf("failed to decode cgroup v1 metrics: %w", err)
+		}
+
+		var metrics executor.ContainerMetrics
+		if data.GetCPU().GetUsage() != nil {
+			metrics.TimeSpentInCPU = time.Duration(data.GetCPU().GetUsage().GetTotal())
+		}
+		if data.GetMemory().GetUsage() != nil {
+			metrics.MemoryUsageInBytes = workingSet(data.GetMemory().GetUsage().GetUsage(), data.GetMemory().GetTotalInactiveFile())
+		}
+		return metrics, nil
+	case typeurl.Is(metric.Data, (*cgroup2stats.Metrics)(nil)):
+		data := &cgroup2stats.Metrics{}
+		if err := typeurl.UnmarshalTo(metric.Data, data); err != nil {
+			return executor.ContainerMetrics{}, fmt.Errorf("failed to decode cgroup v2 metrics: %w", err)
+		}
+
+		return executor.ContainerMetrics{
+			TimeSpentInCPU:     time.Duration(data.GetCPU().GetUsageUsec()) * time.Microsecond,
+			MemoryUsageInBytes: workingSet(data.GetMemory().GetUsage(), data.GetMemory().GetInactiveFile()),
+		}, nil
+	default:
+		return executor.ContainerMetrics{}, errors.New("unsupported task metrics type")
+	}
+}
+
+func workingSet(usage, inactiveFile uint64) uint64 {
+	if inactiveFile > usage {
+		return 0
+	}
+	return usage - inactiveFile
+}
+
+// networkStats reads the receive and transmit byte counters of the pod
+// interface from the network namespace of the given pid.
+func networkStats(pid uint32) (*uint64, *uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(name) != networkInterface {
+			continue
+		}
+
+		// receive bytes is the first column, transmit bytes the ninth
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			return nil, nil, fmt.Errorf("unexpected format for interface %s", networkInterface)
+		}
+
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &rx, &tx, nil
+	}
+
+	return nil, nil, scanner.Err()
 }
@@ -0,0 +1,429 @@
+package containerd_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/containerd"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/containerd/containerdfakes"
+	ctrdclient "github.com/containerd/containerd/v2/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var _ = Describe("Client", func() {
+	const (
+		imageDigest = digest.Digest("sha256:6f2a3bbd0d5cd8de40c8e8a3b1bb9ec3a4d4e7b4d3a5a7f0b1e2c3d4e5f60718")
+		pullTimeout = time.Minute
+	)
+
+	var (
+		client         containerd.Client
+		fakeImageStore *containerdfakes.FakeStore
+		fakeImage      *containerdfakes.FakeImage
+
+		pullsMu     sync.Mutex
+		pulledRefs  []string
+		pullCtxs    []context.Context
+		releasePull chan struct{}
+		pullErr     error
+		diskUsages  atomic.Int32
+	)
+
+	pullCount := func() int {
+		pullsMu.Lock()
+		defer pullsMu.Unlock()
+
+		return len(pulledRefs)
+	}
+
+	type pullResult struct {
+		Img  ctrdclient.Image
+		Size int64
+		Err  error
+	}
+
+	pullAsync := func(ctx context.Context, ref, username, password string) <-chan pullResult {
+		results := make(chan pullResult, 1)
+		go func() {
+			defer GinkgoRecover()
+
+			img, size, err := client.Pull(ctx, ref, username, password, pullTimeout)
+			results <- pullResult{Img: img, Size: size, Err: err}
+		}()
+		return results
+	}
+
+	BeforeEach(func() {
+		pulledRefs = nil
+		pullCtxs = nil
+		releasePull = make(chan struct{})
+		pullErr = nil
+		diskUsages.Store(0)
+
+		fakeImage = &containerdfakes.FakeImage{}
+		fakeImage.NameReturns("docker.io/library/busybox:latest")
+		fakeImage.TargetReturns(ocispec.Descriptor{Digest: imageDigest})
+		fakeImage.RootFSReturns([]digest.Digest{"sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"}, nil)
+
+		fakeImageStore = &containerdfakes.FakeStore{}
+
+		client = containerd.NewTestClientWrapper(
+			containerd.RegistryConfig{},
+			fakeImageStore,
+			func(ctx context.Context, ref string, _ ...ctrdclient.RemoteOpt) (ctrdclient.Image, error) {
+				pullsMu.Lock()
+				pulledRefs = append(pulledRefs, ref)
+				pullCtxs = append(pullCtxs, ctx)
+				release := releasePull
+				pullsMu.Unlock()
+
+				select {
+				case <-release:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				if pullErr != nil {
+					return nil, pullErr
+				}
+				return fakeImage, nil
+			},
+			func(context.Context, digest.Digest) (int64, error) {
+				diskUsages.Add(1)
+				return 4096, nil
+			},
+		)
+	})
+
+	Describe("Pull", func() {
+		It("shares concurrent pulls of the same reference", func() {
+			first := pullAsync(context.Background(), "busybox:latest", "", "")
+			Eventually(pullCount).Should(Equal(1))
+			second := pullAsync(context.Background(), "docker.io/library/busybox:latest", "", "")
+			Consistently(pullCount, 200*time.Millisecond).Should(Equal(1))
+
+			close(releasePull)
+			for _, results := range []<-chan pullResult{first, second} {
+				var result pullResult
+				Eventually(results).Should(Receive(&result))
+				Expect(result.Err).NotTo(HaveOccurred())
+				Expect(result.Img).To(Equal(fakeImage))
+				Expect(result.Size).To(Equal(int64(4096)))
+			}
+			Expect(pulledRefs).To(Equal([]string{"docker.io/library/busybox:latest"}))
+		})
+
+		It("does not share pulls with other credentials", func() {
+			first := pullAsync(context.Background(), "busybox:latest", "user", "password")
+			second := pullAsync(context.Background(), "busybox:latest", "user", "other-password")
+			Eventually(pullCount).Should(Equal(2))
+
+			close(releasePull)
+			Eventually(first).Should(Receive(HaveField("Err", BeNil())))
+			Eventually(second).Should(Receive(HaveField("Err", BeNil())))
+		})
+
+		It("does not fail other callers when one of them is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			first := pullAsync(ctx, "busybox:latest", "", "")
+			Eventually(pullCount).Should(Equal(1))
+			second := pullAsync(context.Background(), "busybox:latest", "", "")
+			Consistently(pullCount, 200*time.Millisecond).Should(Equal(1))
+
+			cancel()
+			Eventually(first).Should(Receive(HaveField("Err", MatchError(context.Canceled))))
+			Expect(pullCtxs[0].Err()).NotTo(HaveOccurred())
+
+			close(releasePull)
+			var result pullResult
+			Eventually(second).Should(Receive(&result))
+			Expect(result.Err).NotTo(HaveOccurred())
+			Expect(result.Img).To(Equal(fakeImage))
+		})
+
+		It("bounds the shared pull with the pull timeout", func() {
+			results := pullAsync(context.Background(), "busybox:latest", "", "")
+			Eventually(pullCount).Should(Equal(1))
+			pullDeadline, ok := pullCtxs[0].Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(pullDeadline).To(BeTemporally("~", time.Now().Add(pullTimeout), 5*time.Second))
+
+			close(releasePull)
+			Eventually(results).Should(Receive(HaveField("Err", BeNil())))
+		})
+
+		It("pulls again after a pull from a registry that does not answer timed out", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+			defer cancel()
+
+			_, _, err := client.Pull(ctx, "busybox:latest", "", "", 50*time.Millisecond)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+
+			close(releasePull)
+			img, _, err := client.Pull(ctx, "busybox:latest", "", "", pullTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(img).To(Equal(fakeImage))
+			Expect(pullCount()).To(Equal(2))
+		})
+
+		It("returns the errors of the pull to all callers", func() {
+			pullErr = errors.New("pull failed")
+
+			first := pullAsync(context.Background(), "busybox:latest", "", "")
+			Eventually(pullCount).Should(Equal(1))
+			second := pullAsync(context.Background(), "busybox:latest", "", "")
+			Consistently(pullCount, 200*time.Millisecond).Should(Equal(1))
+
+			close(releasePull)
+			Eventually(first).Should(Receive(HaveField("Err", MatchError("pull failed"))))
+			Eventually(second).Should(Receive(HaveField("Err", MatchError("pull failed"))))
+		})
+
+		It("fails for invalid references without pulling", func() {
+			_, _, err := client.Pull(context.Background(), "Invalid:Reference", "", "", pullTimeout)
+			Expect(err).To(HaveOccurred())
+			Expect(pullCount()).To(BeZero())
+		})
+
+		Describe("the size of the rootfs", func() {
+			BeforeEach(func() {
+				close(releasePull)
+			})
+
+			It("is computed once and recorded on the image", func() {
+				_, size, err := client.Pull(context.Background(), "busybox:latest", "", "", pullTimeout)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(int64(4096)))
+
+				_, size, err = client.Pull(context.Background(), "busybox:latest", "", "", pullTimeout)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(int64(4096)))
+
+				Expect(pullCount()).To(Equal(2))
+				Expect(diskUsages.Load()).To(Equal(int32(1)))
+				Expect(fakeImageStore.UpdateCallCount()).To(Equal(1))
+				_, img, fieldpaths := fakeImageStore.UpdateArgsForCall(0)
+				Expect(img.Name).To(Equal("docker.io/library/busybox:latest"))
+				Expect(img.Labels).To(HaveKeyWithValue("cloudfoundry.org/rootfs-size", "4096"))
+				Expect(fieldpaths).To(ConsistOf("labels.cloudfoundry.org/rootfs-chain-id", "labels.cloudfoundry.org/rootfs-size"))
+			})
+
+			It("is read from the labels of the image", func() {
+				fakeImage.LabelsReturns(map[string]string{
+					"cloudfoundry.org/rootfs-chain-id": "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4",
+					"cloudfoundry.org/rootfs-size":     "1024",
+				})
+
+				_, size, err := client.Pull(context.Background(), "busybox:latest", "", "", pullTimeout)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(int64(1024)))
+				Expect(diskUsages.Load()).To(BeZero())
+			})
+
+			It("is forgotten once the image is deleted", func() {
+				_, _, err := client.Pull(context.Background(), "busybox:latest", "", "", pullTimeout)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(client.Delete(context.Background(), "docker.io/library/busybox:latest", imageDigest)).To(Succeed())
+				_, name, _ := fakeImageStore.DeleteArgsForCall(0)
+				Expect(name).To(Equal("docker.io/library/busybox:latest"))
+
+				_, _, err = client.Pull(context.Background(), "busybox:latest", "", "", pullTimeout)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(diskUsages.Load()).To(Equal(int32(2)))
+			})
+
+			It("is kept if the image could not be deleted", func() {
+				fakeImageStore.DeleteReturns(errors.New("delete failed"))
+				_, _, err := client.Pull(context.Background(), "busybox:latest", "", "", pullTimeout)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(client.Delete(context.Background(), "docker.io/library/busybox:latest", imageDigest)).To(MatchError("delete failed"))
+
+				_, _, err = client.Pull(context.Background(), "busybox:latest", "", "", pullTimeout)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(diskUsages.Load()).To(Equal(int32(1)))
+			})
+		})
+	})
+})
+
+var _ = Describe("Resolver", func() {
+	const (
+		manifestDigest = "sha256:6f2a3bbd0d5cd8de40c8e8a3b1bb9ec3a4d4e7b4d3a5a7f0b1e2c3d4e5f60718"
+		manifest       = `{"schemaVersion":2}`
+	)
+
+	var (
+		registry, mirror *httptest.Server
+		registryRequests []string
+		mirrorRequests   []string
+		requestsMu       sync.Mutex
+		hostsDir         string
+		caFile           string
+		config           containerd.RegistryConfig
+	)
+
+	newRegistry := func(requests *[]string) *httptest.Server {
+		return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestsMu.Lock()
+			*requests = append(*requests, r.URL.RequestURI())
+			requestsMu.Unlock()
+
+			if !strings.Contains(r.URL.Path, "/manifests/") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", manifestDigest)
+			w.Header().Set("Content-Length", fmt.Sprint(len(manifest)))
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte(manifest))
+			}
+		}))
+	}
+
+	requests := func(requests *[]string) func() []string {
+		return func() []string {
+			requestsMu.Lock()
+			defer requestsMu.Unlock()
+
+			return append([]string{}, *requests...)
+		}
+	}
+
+	writeHostsFile := func(host, content string) {
+		dir := filepath.Join(hostsDir, host)
+		Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "hosts.toml"), []byte(content), 0o644)).To(Succeed())
+	}
+
+	resolve := func(ref string) (ocispec.Descriptor, error) {
+		_, desc, err := containerd.NewResolver(context.Background(), config, "", "").Resolve(context.Background(), ref)
+		return desc, err
+	}
+
+	BeforeEach(func() {
+		registryRequests = nil
+		mirrorRequests = nil
+		registry = newRegistry(&registryRequests)
+		DeferCleanup(registry.Close)
+		mirror = newRegistry(&mirrorRequests)
+		DeferCleanup(mirror.Close)
+
+		hostsDir = GinkgoT().TempDir()
+		caFile = filepath.Join(GinkgoT().TempDir(), "ca.crt")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: registry.Certificate().Raw}), 0o644)).To(Succeed())
+
+		config = containerd.RegistryConfig{HostsDir: hostsDir}
+	})
+
+	It("resolves images from the mirror of the registry in hosts.toml", func() {
+		writeHostsFile("docker.io", fmt.Sprintf(`
+server = %q
+
+[host.%q]
+  capabilities = ["pull", "resolve"]
+  ca = %q
+`, registry.URL, mirror.URL, caFile))
+
+		desc, err := resolve("docker.io/library/busybox:latest")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(desc.Digest).To(Equal(digest.Digest(manifestDigest)))
+		Expect(requests(&mirrorRequests)()).To(ConsistOf("/v2/library/busybox/manifests/latest?ns=docker.io"))
+		Expect(requests(&registryRequests)()).To(BeEmpty())
+	})
+
+	It("falls back to the registry if the mirror fails", func() {
+		mirror.Close()
+		writeHostsFile("docker.io", fmt.Sprintf(`
+server = %q
+ca = %q
+
+[host.%q]
+  capabilities = ["pull", "resolve"]
+  ca = %q
+`, registry.URL, caFile, mirror.URL, caFile))
+
+		_, err := resolve("docker.io/library/busybox:latest")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests(&registryRequests)()).To(ConsistOf(HavePrefix("/v2/library/busybox/manifests/latest")))
+	})
+
+	It("trusts the root CAs of the registry config", func() {
+		writeHostsFile("docker.io", fmt.Sprintf("server = %q\n", registry.URL))
+		config.RootCAs = x509.NewCertPool()
+		config.RootCAs.AddCert(registry.Certificate())
+
+		_, err := resolve("docker.io/library/busybox:latest")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests(&registryRequests)()).NotTo(BeEmpty())
+	})
+
+	It("does not trust the CAs of hosts.toml for other registries", func() {
+		writeHostsFile("docker.io", fmt.Sprintf("server = %q\nca = %q\n", registry.URL, caFile))
+		writeHostsFile("quay.io", fmt.Sprintf("server = %q\n", mirror.URL))
+		config.RootCAs = x509.NewCertPool()
+		resolver := containerd.NewResolver(context.Background(), config, "", "")
+
+		_, _, err := resolver.Resolve(context.Background(), "docker.io/library/busybox:latest")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = resolver.Resolve(context.Background(), "quay.io/library/busybox:latest")
+		Expect(err).To(MatchError(ContainSubstring("certificate")))
+		_, err = resolve("quay.io/library/busybox:latest")
+		Expect(err).To(MatchError(ContainSubstring("certificate")))
+		Expect(requests(&mirrorRequests)()).To(BeEmpty())
+	})
+
+	It("fails for registries with untrusted certificates", func() {
+		writeHostsFile("docker.io", fmt.Sprintf("server = %q\n", registry.URL))
+		config.RootCAs = x509.NewCertPool()
+
+		_, err := resolve("docker.io/library/busybox:latest")
+		Expect(err).To(MatchError(ContainSubstring("certificate")))
+	})
+
+	It("pulls from the registry itself if the hosts dir does not exist", func() {
+		config.HostsDir = filepath.Join(hostsDir, "missing")
+		host := strings.TrimPrefix(registry.URL, "https://")
+
+		_, err := resolve(host + "/library/busybox:latest")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests(&registryRequests)()).To(ContainElement("/v2/library/busybox/manifests/latest"))
+	})
+
+	It("pulls from the registry itself if its hosts.toml is invalid", func() {
+		host := strings.TrimPrefix(registry.URL, "https://")
+		writeHostsFile(strings.ReplaceAll(host, ":", "_")+"_", fmt.Sprintf("server = %q\n[host.%q\n", mirror.URL, mirror.URL))
+
+		_, err := resolve(host + "/library/busybox:latest")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests(&registryRequests)()).To(ContainElement("/v2/library/busybox/manifests/latest"))
+		Expect(requests(&mirrorRequests)()).To(BeEmpty())
+	})
+
+	It("fails if the hosts dir is not a directory", func() {
+		config.HostsDir = caFile
+
+		_, err := resolve("docker.io/library/busybox:latest")
+		Expect(err).To(MatchError(ContainSubstring("not a directory")))
+		Expect(requests(&registryRequests)()).To(BeEmpty())
+	})
+})
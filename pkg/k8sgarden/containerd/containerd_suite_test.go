@@ -0,0 +1,13 @@
+package containerd_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestContainerd(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Containerd Suite")
+}
@@ -4,18 +4,22 @@ package containerdfakes
 import (
 	"context"
 	"sync"
+	"time"
 
+	"code.cloudfoundry.org/executor"
 	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/containerd"
 	"github.com/containerd/containerd/v2/client"
+	digest "github.com/opencontainers/go-digest"
 	v1 "k8s.io/api/core/v1"
 )
 
 type FakeClient struct {
-	DeleteStub        func(context.Context, client.Image) error
+	DeleteStub        func(context.Context, string, digest.Digest) error
 	deleteMutex       sync.RWMutex
 	deleteArgsForCall []struct {
 		arg1 context.Context
-		arg2 client.Image
+		arg2 string
+		arg3 digest.Digest
 	}
 	deleteReturns struct {
 		result1 error
@@ -23,6 +27,19 @@ type FakeClient struct {
 	deleteReturnsOnCall map[int]struct {
 		result1 error
 	}
+	ImageStoreUsageStub        func(context.Context) (int64, error)
+	imageStoreUsageMutex       sync.RWMutex
+	imageStoreUsageArgsForCall []struct {
+		arg1 context.Context
+	}
+	imageStoreUsageReturns struct {
+		result1 int64
+		result2 error
+	}
+	imageStoreUsageReturnsOnCall map[int]struct {
+		result1 int64
+		result2 error
+	}
 	IsServingStub        func(context.Context) (bool, error)
 	isServingMutex       sync.RWMutex
 	isServingArgsForCall []struct {
@@ -50,13 +67,28 @@ type FakeClient struct {
 		result1 map[string]client.Task
 		result2 error
 	}
-	PullStub        func(context.Context, string, string, string) (client.Image, int64, error)
+	MetricsStub        func(context.Context, client.Task) (executor.ContainerMetrics, error)
+	metricsMutex       sync.RWMutex
+	metricsArgsForCall []struct {
+		arg1 context.Context
+		arg2 client.Task
+	}
+	metricsReturns struct {
+		result1 executor.ContainerMetrics
+		result2 error
+	}
+	metricsReturnsOnCall map[int]struct {
+		result1 executor.ContainerMetrics
+		result2 error
+	}
+	PullStub        func(context.Context, string, string, string, time.Duration) (client.Image, int64, error)
 	pullMutex       sync.RWMutex
 	pullArgsForCall []struct {
 		arg1 context.Context
 		arg2 string
 		arg3 string
 		arg4 string
+		arg5 time.Duration
 	}
 	pullReturns struct {
 		result1 client.Image
@@ -72,19 +104,20 @@ type FakeClient struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeClient) Delete(arg1 context.Context, arg2 client.Image) error {
+func (fake *FakeClient) Delete(arg1 context.Context, arg2 string, arg3 digest.Digest) error {
 	fake.deleteMutex.Lock()
 	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
 	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
 		arg1 context.Context
-		arg2 client.Image
-	}{arg1, arg2})
+		arg2 string
+		arg3 digest.Digest
+	}{arg1, arg2, arg3})
 	stub := fake.DeleteStub
 	fakeReturns := fake.deleteReturns
-	fake.recordInvocation("Delete", []interface{}{arg1, arg2})
+	fake.recordInvocation("Delete", []interface{}{arg1, arg2, arg3})
 	fake.deleteMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2)
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
@@ -98,17 +131,17 @@ func (fake *FakeClient) DeleteCallCount() int {
 	return len(fake.deleteArgsForCall)
 }
 
-func (fake *FakeClient) DeleteCalls(stub func(context.Context, client.Image) error) {
+func (fake *FakeClient) DeleteCalls(stub func(context.Context, string, digest.Digest) error) {
 	fake.deleteMutex.Lock()
 	defer fake.deleteMutex.Unlock()
 	fake.DeleteStub = stub
 }
 
-func (fake *FakeClient) DeleteArgsForCall(i int) (context.Context, client.Image) {
+func (fake *FakeClient) DeleteArgsForCall(i int) (context.Context, string, digest.Digest) {
 	fake.deleteMutex.RLock()
 	defer fake.deleteMutex.RUnlock()
 	argsForCall := fake.deleteArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *FakeClient) DeleteReturns(result1 error) {
@@ -134,6 +167,70 @@ func (fake *FakeClient) DeleteReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeClient) ImageStoreUsage(arg1 context.Context) (int64, error) {
+	fake.imageStoreUsageMutex.Lock()
+	ret, specificReturn := fake.imageStoreUsageReturnsOnCall[len(fake.imageStoreUsageArgsForCall)]
+	fake.imageStoreUsageArgsForCall = append(fake.imageStoreUsageArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.ImageStoreUsageStub
+	fakeReturns := fake.imageStoreUsageReturns
+	fake.recordInvocation("ImageStoreUsage", []interface{}{arg1})
+	fake.imageStoreUsageMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) ImageStoreUsageCallCount() int {
+	fake.imageStoreUsageMutex.RLock()
+	defer fake.imageStoreUsageMutex.RUnlock()
+	return len(fake.imageStoreUsageArgsForCall)
+}
+
+func (fake *FakeClient) ImageStoreUsageCalls(stub func(context.Context) (int64, error)) {
+	fake.imageStoreUsageMutex.Lock()
+	defer fake.imageStoreUsageMutex.Unlock()
+	fake.ImageStoreUsageStub = stub
+}
+
+func (fake *FakeClient) ImageStoreUsageArgsForCall(i int) context.Context {
+	fake.imageStoreUsageMutex.RLock()
+	defer fake.imageStoreUsageMutex.RUnlock()
+	argsForCall := fake.imageStoreUsageArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeClient) ImageStoreUsageReturns(result1 int64, result2 error) {
+	fake.imageStoreUsageMutex.Lock()
+	defer fake.imageStoreUsageMutex.Unlock()
+	fake.ImageStoreUsageStub = nil
+	fake.imageStoreUsageReturns = struct {
+		result1 int64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) ImageStoreUsageReturnsOnCall(i int, result1 int64, result2 error) {
+	fake.imageStoreUsageMutex.Lock()
+	defer fake.imageStoreUsageMutex.Unlock()
+	fake.ImageStoreUsageStub = nil
+	if fake.imageStoreUsageReturnsOnCall == nil {
+		fake.imageStoreUsageReturnsOnCall = make(map[int]struct {
+			result1 int64
+			result2 error
+		})
+	}
+	fake.imageStoreUsageReturnsOnCall[i] = struct {
+		result1 int64
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) IsServing(arg1 context.Context) (bool, error) {
 	fake.isServingMutex.Lock()
 	ret, specificReturn := fake.isServingReturnsOnCall[len(fake.isServingArgsForCall)]
@@ -268,7 +365,72 @@ func (fake *FakeClient) LoadTasksReturnsOnCall(i int, result1 map[string]client.
 	}{result1, result2}
 }
 
-func (fake *FakeClient) Pull(arg1 context.Context, arg2 string, arg3 string, arg4 string) (client.Image, int64, error) {
+func (fake *FakeClient) Metrics(arg1 context.Context, arg2 client.Task) (executor.ContainerMetrics, error) {
+	fake.metricsMutex.Lock()
+	ret, specificReturn := fake.metricsReturnsOnCall[len(fake.metricsArgsForCall)]
+	fake.metricsArgsForCall = append(fake.metricsArgsForCall, struct {
+		arg1 context.Context
+		arg2 client.Task
+	}{arg1, arg2})
+	stub := fake.MetricsStub
+	fakeReturns := fake.metricsReturns
+	fake.recordInvocation("Metrics", []interface{}{arg1, arg2})
+	fake.metricsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) MetricsCallCount() int {
+	fake.metricsMutex.RLock()
+	defer fake.metricsMutex.RUnlock()
+	return len(fake.metricsArgsForCall)
+}
+
+func (fake *FakeClient) MetricsCalls(stub func(context.Context, client.Task) (executor.ContainerMetrics, error)) {
+	fake.metricsMutex.Lock()
+	defer fake.metricsMutex.Unlock()
+	fake.MetricsStub = stub
+}
+
+func (fake *FakeClient) MetricsArgsForCall(i int) (context.Context, client.Task) {
+	fake.metricsMutex.RLock()
+	defer fake.metricsMutex.RUnlock()
+	argsForCall := fake.metricsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) MetricsReturns(result1 executor.ContainerMetrics, result2 error) {
+	fake.metricsMutex.Lock()
+	defer fake.metricsMutex.Unlock()
+	fake.MetricsStub = nil
+	fake.metricsReturns = struct {
+		result1 executor.ContainerMetrics
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) MetricsReturnsOnCall(i int, result1 executor.ContainerMetrics, result2 error) {
+	fake.metricsMutex.Lock()
+	defer fake.metricsMutex.Unlock()
+	fake.MetricsStub = nil
+	if fake.metricsReturnsOnCall == nil {
+		fake.metricsReturnsOnCall = make(map[int]struct {
+			result1 executor.ContainerMetrics
+			result2 error
+		})
+	}
+	fake.metricsReturnsOnCall[i] = struct {
+		result1 executor.ContainerMetrics
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) Pull(arg1 context.Context, arg2 string, arg3 string, arg4 string, arg5 time.Duration) (client.Image, int64, error) {
 	fake.pullMutex.Lock()
 	ret, specificReturn := fake.pullReturnsOnCall[len(fake.pullArgsForCall)]
 	fake.pullArgsForCall = append(fake.pullArgsForCall, struct {
@@ -276,13 +438,14 @@ func (fake *FakeClient) Pull(arg1 context.Context, arg2 string, arg3 string, arg
 		arg2 string
 		arg3 string
 		arg4 string
-	}{arg1, arg2, arg3, arg4})
+		arg5 time.Duration
+	}{arg1, arg2, arg3, arg4, arg5})
 	stub := fake.PullStub
 	fakeReturns := fake.pullReturns
-	fake.recordInvocation("Pull", []interface{}{arg1, arg2, arg3, arg4})
+	fake.recordInvocation("Pull", []interface{}{arg1, arg2, arg3, arg4, arg5})
 	fake.pullMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2, arg3, arg4)
+		return stub(arg1, arg2, arg3, arg4, arg5)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2, ret.result3
@@ -296,17 +459,17 @@ func (fake *FakeClient) PullCallCount() int {
 	return len(fake.pullArgsForCall)
 }
 
-func (fake *FakeClient) PullCalls(stub func(context.Context, string, string, string) (client.Image, int64, error)) {
+func (fake *FakeClient) PullCalls(stub func(context.Context, string, string, string, time.Duration) (client.Image, int64, error)) {
 	fake.pullMutex.Lock()
 	defer fake.pullMutex.Unlock()
 	fake.PullStub = stub
 }
 
-func (fake *FakeClient) PullArgsForCall(i int) (context.Context, string, string, string) {
+func (fake *FakeClient) PullArgsForCall(i int) (context.Context, string, string, string, time.Duration) {
 	fake.pullMutex.RLock()
 	defer fake.pullMutex.RUnlock()
 	argsForCall := fake.pullArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4, argsForCall.arg5
 }
 
 func (fake *FakeClient) PullReturns(result1 client.Image, result2 int64, result3 error) {
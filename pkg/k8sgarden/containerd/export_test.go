@@ -0,0 +1,21 @@
+package containerd
+
+import (
+	"context"
+
+	ctrdclient "github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/opencontainers/go-digest"
+)
+
+// NewTestClientWrapper returns a client that pulls with pullImage and
+// computes the size of the rootfs of images with diskUsage.
+func NewTestClientWrapper(registries RegistryConfig, imageStore images.Store, pullImage func(context.Context, string, ...ctrdclient.RemoteOpt) (ctrdclient.Image, error), diskUsage func(context.Context, digest.Digest) (int64, error)) Client {
+	return newClientWrapper(registries, imageStore, pullImage, diskUsage)
+}
+
+// NewResolver returns the resolver the client pulls images with.
+func NewResolver(ctx context.Context, registries RegistryConfig, username, password string) remotes.Resolver {
+	return newClientWrapper(registries, nil, nil, nil).resolver(ctx, username, password)
+}
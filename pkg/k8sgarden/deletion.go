@@ -0,0 +1,186 @@
+package k8sgarden
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// terminationGracePeriodSeconds returns the termination grace period of the
+// pod of a container with the given grace time, rounded up to full seconds.
+func terminationGracePeriodSeconds(graceTime time.Duration) int64 {
+	if graceTime <= 0 {
+		graceTime = defaultStopGraceTime
+	}
+
+	return int64(math.Ceil(graceTime.Seconds()))
+}
+
+// deletionReconciler deletes the pods of destroyed containers in the
+// background. It retries every poll interval until the pods are gone, and
+// cleans up after the containers only then.
+type deletionReconciler struct {
+	client  *client
+	log     lager.Logger
+	trigger chan struct{}
+}
+
+func newDeletionReconciler(c *client) *deletionReconciler {
+	return &deletionReconciler{
+		client:  c,
+		log:     c.logger.Session("deletion-reconciler"),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// enqueue makes the reconciler look at the destroyed containers right away.
+func (r *deletionReconciler) enqueue() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// podEventHandler enqueues the reconciler whenever a pod is deleted, so that
+// destroyed containers are cleaned up as soon as their pods are gone.
+func (r *deletionReconciler) podEventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(any) { r.enqueue() },
+	}
+}
+
+// run reconciles the destroyed containers until ctx is done. The retry timer
+// is only started while containers are waiting for their pods to be gone.
+func (r *deletionReconciler) run(ctx context.Context) {
+	for {
+		pending := r.reconcile(ctx)
+
+		var (
+			retry <-chan time.Time
+			timer clock.Timer
+		)
+		if pending {
+			timer = r.client.deadlines.clock.NewTimer(r.client.deadlines.timeouts.PollInterval)
+			retry = timer.C()
+		}
+
+		select {
+		case <-r.trigger:
+		case <-retry:
+		case <-ctx.Done():
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// reconcile deletes the pods of all destroyed containers and reports whether
+// any of them is not gone yet. It stops early once ctx is done.
+func (r *deletionReconciler) reconcile(ctx context.Context) bool {
+	pending := false
+	for _, cntr := range r.client.containers.List() {
+		container := cntr.(*container)
+		if !container.isDestroying() {
+			continue
+		}
+		if ctx.Err() != nil {
+			return pending
+		}
+
+		gone, err := r.deletePod(container)
+		if err != nil {
+			r.log.Error("failed-to-delete-pod", err, lager.Data{"handle": container.Handle()})
+		}
+		if !gone {
+			pending = true
+			continue
+		}
+
+		if err := r.client.cleanUp(container); err != nil {
+			r.log.Error("failed-to-clean-up", err, lager.Data{"handle": container.Handle()})
+			pending = true
+			continue
+		}
+		r.log.Info("destroyed", lager.Data{"handle": container.Handle()})
+	}
+
+	return pending
+}
+
+// deletePod deletes the pod of the container with the grace time of the
+// container, unless it is terminating already, and reports whether it is gone.
+func (r *deletionReconciler) deletePod(container *container) (bool, error) {
+	pod := container.currentPod()
+
+	current, err := r.client.getPod(pod)
+	if err != nil {
+		return podGone(err)
+	}
+
+	if current.DeletionTimestamp == nil {
+		ctx, cancel := r.client.deadlines.apiContext()
+		defer cancel()
+
+		gracePeriod := terminationGracePeriodSeconds(container.graceTime())
+		if err := r.client.k8sclient.Delete(ctx, current, ctrlclient.GracePeriodSeconds(gracePeriod)); err != nil {
+			return podGone(err)
+		}
+	}
+
+	// pods without containers to stop can be gone right away
+	_, err = r.client.getPod(pod)
+	return podGone(err)
+}
+
+// podGone reports whether the error of a request for a pod tells that the
+// pod is gone, or returns the error otherwise.
+func podGone(err error) (bool, error) {
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// cleanUp removes everything the container left behind once its pod is gone.
+// The steps that can fail come first, so that the in-memory state is only
+// released once and not again when the cleanup is retried.
+func (c *client) cleanUp(container *container) error {
+	handle := container.Handle()
+	pod := container.currentPod()
+
+	if err := deleteNetOutPolicy(c.k8sclient, c.deadlines, handle, pod.Namespace); err != nil {
+		return fmt.Errorf("failed to delete network policy: %w", err)
+	}
+
+	if err := deleteStateSecret(c.k8sclient, c.deadlines, handle, pod.Namespace); err != nil {
+		return fmt.Errorf("failed to delete state secret: %w", err)
+	}
+
+	if err := container.removeNetIns(); err != nil {
+		return fmt.Errorf("failed to remove port forwarding: %w", err)
+	}
+
+	if err := c.propertyManager.DestroyKeySpace(handle); err != nil {
+		return err
+	}
+
+	container.releasePorts()
+	c.images.release(handle)
+	c.containers.Remove(handle)
+
+	return nil
+}
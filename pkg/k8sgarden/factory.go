@@ -7,17 +7,45 @@ import (
 )
 
 type factory struct {
-	client garden.Client
+	client   garden.Client
+	restored *RestoredContainers
 }
 
 var _ containerstore.GardenClientFactory = &factory{}
 
-func NewFactory(client garden.Client) containerstore.GardenClientFactory {
+func NewFactory(client garden.Client, restored *RestoredContainers) containerstore.GardenClientFactory {
 	return &factory{
-		client: client,
+		client:   client,
+		restored: restored,
 	}
 }
 
 func (f *factory) NewGardenClient(logger lager.Logger, traceID string) garden.Client {
-	return f.client
+	return &storeClient{
+		Client:   f.client,
+		restored: f.restored,
+	}
+}
+
+// storeClient hides the restored containers from the container store, which
+// would otherwise reap them as they are unknown to it.
+type storeClient struct {
+	garden.Client
+	restored *RestoredContainers
+}
+
+func (c *storeClient) Containers(properties garden.Properties) ([]garden.Container, error) {
+	containers, err := c.Client.Containers(properties)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]garden.Container, 0, len(containers))
+	for _, container := range containers {
+		if !c.restored.Contains(container.Handle()) {
+			filtered = append(filtered, container)
+		}
+	}
+
+	return filtered, nil
 }
@@ -0,0 +1,361 @@
+package k8sgarden
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden/containerd"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultImageGCIdlePeriod = time.Hour
+
+	// imageGCRetryInterval is how often removing images is retried after it
+	// failed or images were being pulled.
+	imageGCRetryInterval = time.Minute
+)
+
+// ImageDigestAnnotationKey holds the digest of the image pulled for the app
+// container of the pod, so that the images in use can be restored from the
+// pods.
+const ImageDigestAnnotationKey = "cloudfoundry.org/image-digest"
+
+// ImageGC configures the removal of pulled images that no container uses
+// anymore.
+type ImageGC struct {
+	// IdlePeriod is how long an image is kept after the last container using
+	// it was destroyed, so that apps that are restarted right away do not pull
+	// it again.
+	IdlePeriod time.Duration
+	// Threshold is the size of the image store in bytes above which unused
+	// images are removed without waiting for the idle period, the longest
+	// unused ones first. Zero disables it. The size is that of all content
+	// in the image store of containerd, including images that were not pulled
+	// for containers, e.g. by kubelet, which are never removed.
+	Threshold int64
+}
+
+// pulledImage identifies an image pulled into containerd for a container.
+// Images of different names may share a digest, and with it their content.
+type pulledImage struct {
+	name   string
+	digest digest.Digest
+}
+
+// podImage returns the image pulled for the app container of the pod, if
+// any.
+func podImage(pod *corev1.Pod) (pulledImage, bool) {
+	value, ok := pod.Annotations[ImageDigestAnnotationKey]
+	if !ok {
+		return pulledImage{}, false
+	}
+
+	for _, container := range pod.Spec.Containers {
+		if container.Name == appContainerName {
+			return pulledImage{name: container.Image, digest: digest.Digest(value)}, true
+		}
+	}
+
+	return pulledImage{}, false
+}
+
+// imageUsers are the handles of the containers using an image digest, and
+// the names the digest was pulled as.
+type imageUsers struct {
+	names       map[string]bool
+	handles     map[string]bool
+	unusedSince time.Time
+}
+
+// imageCollector counts the containers using each pulled image digest, and
+// removes the images no container uses anymore. Images are tracked by
+// digest, so that the content shared by images of different names is only
+// removed once none of them is used. Like the deletion reconciler, its timer
+// only runs while there are unused images.
+type imageCollector struct {
+	containerdClient containerd.Client
+	deadlines        deadlines
+	config           ImageGC
+	log              lager.Logger
+	trigger          chan struct{}
+
+	// pulls is held for reading from pulling an image until the container
+	// references it, so that the image is not removed in between. Images are
+	// only removed while no pull is running, without waiting for pulls, which
+	// may take as long as the pull timeout.
+	pulls sync.RWMutex
+
+	mu      sync.Mutex
+	images  map[digest.Digest]*imageUsers
+	handles map[string]digest.Digest
+}
+
+func newImageCollector(log lager.Logger, containerdClient containerd.Client, d deadlines, config ImageGC) *imageCollector {
+	return &imageCollector{
+		containerdClient: containerdClient,
+		deadlines:        d,
+		config:           config,
+		log:              log,
+		trigger:          make(chan struct{}, 1),
+		images:           map[digest.Digest]*imageUsers{},
+		handles:          map[string]digest.Digest{},
+	}
+}
+
+// enqueue makes the collector look at the images right away.
+func (g *imageCollector) enqueue() {
+	select {
+	case g.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// pulling is called before an image is pulled for a container. The returned
+// function must be called once the container references the image, or
+// failed to pull it.
+func (g *imageCollector) pulling() func() {
+	g.pulls.RLock()
+	return g.pulls.RUnlock
+}
+
+// acquire records that the container uses the image.
+func (g *imageCollector) acquire(handle string, img pulledImage) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	users, ok := g.images[img.digest]
+	if !ok {
+		users = &imageUsers{names: map[string]bool{}, handles: map[string]bool{}}
+		g.images[img.digest] = users
+	}
+	users.names[img.name] = true
+	users.handles[handle] = true
+	users.unusedSince = time.Time{}
+	g.handles[handle] = img.digest
+
+	// the image store grew
+	g.enqueue()
+}
+
+// release records that the container no longer uses its image. It does
+// nothing for containers without a pulled image, or if called again.
+func (g *imageCollector) release(handle string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, unused := g.releaseLocked(handle); unused {
+		g.enqueue()
+	}
+}
+
+// discard releases the image of a container that could not be created, and
+// removes it right away if no other container uses it. The image is left to
+// the collector if other images are being pulled.
+func (g *imageCollector) discard(handle string) error {
+	g.mu.Lock()
+	dgst, unused := g.releaseLocked(handle)
+	g.mu.Unlock()
+
+	if !unused {
+		return nil
+	}
+
+	if !g.pulls.TryLock() {
+		g.enqueue()
+		return nil
+	}
+	defer g.pulls.Unlock()
+
+	return g.remove(dgst)
+}
+
+// releaseLocked releases the image of the container and returns its digest
+// if no container uses it anymore.
+func (g *imageCollector) releaseLocked(handle string) (digest.Digest, bool) {
+	dgst, ok := g.handles[handle]
+	if !ok {
+		return "", false
+	}
+	delete(g.handles, handle)
+
+	users := g.images[dgst]
+	delete(users.handles, handle)
+	if len(users.handles) > 0 {
+		return "", false
+	}
+
+	users.unusedSince = g.deadlines.clock.Now()
+	return dgst, true
+}
+
+// run collects the unused images until ctx is done.
+func (g *imageCollector) run(ctx context.Context) {
+	for {
+		next, pending := g.collect()
+
+		var (
+			retry <-chan time.Time
+			timer clock.Timer
+		)
+		if pending {
+			timer = g.deadlines.clock.NewTimer(next)
+			retry = timer.C()
+		}
+
+		select {
+		case <-g.trigger:
+		case <-retry:
+		case <-ctx.Done():
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// collect removes the images that were unused for the idle period, and the
+// longest unused ones while the image store is above the threshold. It
+// returns how long it is until the next unused image is due, if there is
+// any.
+func (g *imageCollector) collect() (time.Duration, bool) {
+	if !g.pulls.TryLock() {
+		return imageGCRetryInterval, len(g.unused()) > 0
+	}
+	defer g.pulls.Unlock()
+
+	now := g.deadlines.clock.Now()
+	remaining := []digest.Digest{}
+	next := time.Duration(0)
+	for _, dgst := range g.unused() {
+		idle := now.Sub(g.unusedSince(dgst))
+		if idle < g.config.IdlePeriod {
+			remaining = append(remaining, dgst)
+			continue
+		}
+
+		if err := g.remove(dgst); err != nil {
+			g.log.Error("failed-to-remove-image", err, lager.Data{"digest": dgst})
+			remaining = append(remaining, dgst)
+			next = imageGCRetryInterval
+		}
+	}
+
+	if g.config.Threshold > 0 {
+		remaining = g.shrink(remaining)
+	}
+
+	for _, dgst := range remaining {
+		due := g.config.IdlePeriod - now.Sub(g.unusedSince(dgst))
+		if due > 0 && (next == 0 || due < next) {
+			next = due
+		}
+	}
+
+	return next, len(remaining) > 0
+}
+
+// shrink removes the given unused images in order while the image store is
+// above the threshold, and returns the ones that are left. The usage is that
+// of the whole content store, so all unused images are removed if content
+// the collector does not track keeps it above the threshold.
+func (g *imageCollector) shrink(unused []digest.Digest) []digest.Digest {
+	for len(unused) > 0 {
+		ctx, cancel := g.deadlines.apiContext()
+		usage, err := g.containerdClient.ImageStoreUsage(ctx)
+		cancel()
+		if err != nil {
+			g.log.Error("failed-to-get-image-store-usage", err)
+			return unused
+		}
+
+		if usage <= g.config.Threshold {
+			return unused
+		}
+
+		dgst := unused[0]
+		if err := g.remove(dgst); err != nil {
+			g.log.Error("failed-to-remove-image", err, lager.Data{"digest": dgst})
+			return unused
+		}
+		unused = unused[1:]
+	}
+
+	return unused
+}
+
+// unused returns the image digests no container uses, the longest unused
+// first.
+func (g *imageCollector) unused() []digest.Digest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	unused := []digest.Digest{}
+	for dgst, users := range g.images {
+		if len(users.handles) == 0 {
+			unused = append(unused, dgst)
+		}
+	}
+	slices.SortFunc(unused, func(a, b digest.Digest) int {
+		return g.images[a].unusedSince.Compare(g.images[b].unusedSince)
+	})
+
+	return unused
+}
+
+func (g *imageCollector) unusedSince(dgst digest.Digest) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.images[dgst].unusedSince
+}
+
+// remove deletes all names of an unused image digest from containerd and
+// forgets about it. Names that were deleted are forgotten even if deleting
+// another one fails. It must be called with the pulls locked for writing.
+func (g *imageCollector) remove(dgst digest.Digest) error {
+	g.mu.Lock()
+	names := slices.Sorted(maps.Keys(g.images[dgst].names))
+	g.mu.Unlock()
+
+	for _, name := range names {
+		if err := g.deleteImage(name, dgst); err != nil {
+			return err
+		}
+
+		g.mu.Lock()
+		delete(g.images[dgst].names, name)
+		g.mu.Unlock()
+	}
+
+	g.mu.Lock()
+	delete(g.images, dgst)
+	g.mu.Unlock()
+
+	g.log.Info("removed-image", lager.Data{"images": names, "digest": dgst})
+	return nil
+}
+
+func (g *imageCollector) deleteImage(name string, dgst digest.Digest) error {
+	ctx, cancel := g.deadlines.apiContext()
+	defer cancel()
+
+	if err := g.containerdClient.Delete(ctx, name, dgst); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to delete image %s: %w", name, err)
+	}
+
+	return nil
+}
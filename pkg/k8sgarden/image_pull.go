@@ -0,0 +1,152 @@
+package k8sgarden
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	ctrdclient "github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	remoteerrors "github.com/containerd/containerd/v2/core/remotes/errors"
+	"github.com/containerd/errdefs"
+)
+
+const (
+	defaultImagePullTimeout  = 5 * time.Minute
+	defaultImagePullAttempts = 3
+
+	// imagePullInitialBackoff is the wait before the first retry of a pull,
+	// which doubles with every further retry up to imagePullMaxBackoff.
+	imagePullInitialBackoff = time.Second
+	imagePullMaxBackoff     = 30 * time.Second
+)
+
+// The kinds of [ImagePullError]. Their messages tell app developers what to
+// do about the failure, as the message of the error is all that reaches them.
+var (
+	ErrImageUnauthorized        = errors.New("the registry denied access to the image, check the image name and the registry credentials")
+	ErrImageNotFound            = errors.New("the image was not found, check the image name and tag")
+	ErrImageManifestUnsupported = errors.New("the image has no manifest for the platform of the cell or uses an unsupported format")
+	ErrImageRateLimited         = errors.New("the registry rate limit was exceeded, try again later or pull with registry credentials")
+	ErrImageRegistryUnreachable = errors.New("the registry could not be reached")
+)
+
+// ImagePull configures how docker images are pulled.
+type ImagePull struct {
+	// Timeout bounds each attempt to pull an image.
+	Timeout time.Duration
+	// Attempts is how often a pull is attempted if the registry is rate
+	// limited or can not be reached.
+	Attempts int
+}
+
+// ImagePullError is returned by Create if the docker image of the container
+// can not be pulled. Kind is one of the ErrImage errors and can be checked
+// with [errors.Is] within the rep. The executor only keeps the message of the
+// error as the failure reason of the container, so the kind reaches app
+// developers and the BBS as part of that text only.
+type ImagePullError struct {
+	Image string
+	Kind  error
+	Err   error
+}
+
+func (e ImagePullError) Error() string {
+	return fmt.Sprintf("failed to pull docker image %s: %v: %v", e.Image, e.Kind, e.Err)
+}
+
+func (e ImagePullError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// transient reports whether pulling the image again may succeed.
+func (e ImagePullError) transient() bool {
+	return e.Kind == ErrImageRateLimited || e.Kind == ErrImageRegistryUnreachable
+}
+
+// imagePullErrorKind classifies the error of a pull, or returns nil if it is
+// none of the known kinds.
+func imagePullErrorKind(err error) error {
+	var status remoteerrors.ErrUnexpectedStatus
+	hasStatus := errors.As(err, &status)
+
+	switch {
+	case strings.Contains(err.Error(), "no match for platform"),
+		strings.Contains(err.Error(), "unexpected media type"),
+		errdefs.IsNotImplemented(err):
+		return ErrImageManifestUnsupported
+	case errors.Is(err, docker.ErrInvalidAuthorization),
+		errdefs.IsUnauthorized(err),
+		errdefs.IsPermissionDenied(err),
+		hasStatus && (status.StatusCode == http.StatusUnauthorized || status.StatusCode == http.StatusForbidden):
+		return ErrImageUnauthorized
+	case hasStatus && status.StatusCode == http.StatusTooManyRequests:
+		return ErrImageRateLimited
+	case errdefs.IsNotFound(err),
+		hasStatus && status.StatusCode == http.StatusNotFound:
+		return ErrImageNotFound
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errdefs.IsUnavailable(err) ||
+		hasStatus && status.StatusCode >= http.StatusInternalServerError {
+		return ErrImageRegistryUnreachable
+	}
+
+	return nil
+}
+
+// pullImage pulls the docker image of the container and records that the
+// container uses it. Pulls that fail because the registry is rate limited
+// or can not be reached are retried with exponential backoff.
+func (c *client) pullImage(handle, ref, username, password string) (ctrdclient.Image, int64, error) {
+	log := c.logger.Session("pull-image", lager.Data{"handle": handle, "image": ref})
+
+	backoff := imagePullInitialBackoff
+	for attempt := 1; ; attempt++ {
+		img, size, err := c.pullImageOnce(handle, ref, username, password)
+		if err == nil {
+			return img, size, nil
+		}
+
+		kind := imagePullErrorKind(err)
+		if kind == nil {
+			return nil, 0, fmt.Errorf("failed to pull docker image %s: %w", ref, err)
+		}
+
+		pullErr := ImagePullError{Image: ref, Kind: kind, Err: err}
+		if !pullErr.transient() || attempt >= c.imagePull.Attempts {
+			return nil, 0, pullErr
+		}
+
+		log.Error("failed-to-pull-image", err, lager.Data{"attempt": attempt, "retry-in": backoff.String()})
+		c.deadlines.clock.Sleep(backoff)
+		backoff = min(2*backoff, imagePullMaxBackoff)
+	}
+}
+
+// pullImageOnce makes one attempt to pull the image, bounded by the pull
+// timeout. The context only bounds waiting for the pull, which may be shared
+// with other containers, the pull itself is bounded by the containerd client.
+func (c *client) pullImageOnce(handle, ref, username, password string) (ctrdclient.Image, int64, error) {
+	donePulling := c.images.pulling()
+	defer donePulling()
+
+	ctx, cancel := c.deadlines.withTimeout(context.Background(), c.imagePull.Timeout)
+	defer cancel()
+
+	img, size, err := c.containerdClient.Pull(ctx, ref, username, password, c.imagePull.Timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.images.acquire(handle, pulledImage{name: img.Name(), digest: img.Target().Digest})
+
+	return img, size, nil
+}
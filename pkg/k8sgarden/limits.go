@@ -0,0 +1,102 @@
+package k8sgarden
+
+import (
+	"fmt"
+	"strconv"
+
+	"code.cloudfoundry.org/garden"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// RootfsSizeAnnotationKey holds the size of the pulled image, which is
+	// part of the disk limit of the container but not of the ephemeral
+	// storage limit of the pod.
+	RootfsSizeAnnotationKey = "cloudfoundry.org/rootfs-size"
+
+	// IngressBandwidthAnnotationKey and EgressBandwidthAnnotationKey are the
+	// traffic shaping annotations of the CNI bandwidth plugin, in bits per
+	// second.
+	IngressBandwidthAnnotationKey = "kubernetes.io/ingress-bandwidth"
+	EgressBandwidthAnnotationKey  = "kubernetes.io/egress-bandwidth"
+
+	// BandwidthBurstAnnotationKey holds the burst of the bandwidth limits in
+	// bytes per second. The bandwidth plugin has no annotation for the burst,
+	// so it only serves to report the limits back.
+	BandwidthBurstAnnotationKey = "cloudfoundry.org/bandwidth-burst"
+
+	// cpuSharesPerCore is the factor kubelet uses to convert CPU requests into
+	// cgroup CPU shares.
+	cpuSharesPerCore = 1024
+)
+
+// podCPUAssignment returns the number of cores requested by the pod.
+func podCPUAssignment(pod *corev1.Pod) float64 {
+	if pod.Spec.Resources == nil {
+		return 0
+	}
+
+	return pod.Spec.Resources.Requests.Cpu().AsApproximateFloat64()
+}
+
+// podRootfsSize returns the image size recorded on the pod.
+func podRootfsSize(pod *corev1.Pod) (uint64, error) {
+	value, ok := pod.Annotations[RootfsSizeAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse annotation %s of pod %s: %w", RootfsSizeAnnotationKey, pod.Name, err)
+	}
+
+	return size, nil
+}
+
+// bandwidthAnnotations returns the shaping annotations limiting the traffic
+// of the pod in both directions, as garden did.
+func bandwidthAnnotations(limits garden.BandwidthLimits) map[string]string {
+	rate := resource.NewQuantity(int64(limits.RateInBytesPerSecond*8), resource.DecimalSI).String()
+	annotations := map[string]string{
+		IngressBandwidthAnnotationKey: rate,
+		EgressBandwidthAnnotationKey:  rate,
+	}
+	if limits.BurstRateInBytesPerSecond > 0 {
+		annotations[BandwidthBurstAnnotationKey] = strconv.FormatUint(limits.BurstRateInBytesPerSecond, 10)
+	}
+
+	return annotations
+}
+
+// podBandwidthLimits returns the bandwidth limits of the shaping annotations
+// of the pod. The ingress limit is the one garden applied, the egress limit is
+// only used if the pod has no ingress limit.
+func podBandwidthLimits(pod *corev1.Pod) (garden.BandwidthLimits, error) {
+	limits := garden.BandwidthLimits{}
+	for _, key := range []string{IngressBandwidthAnnotationKey, EgressBandwidthAnnotationKey} {
+		value, ok := pod.Annotations[key]
+		if !ok {
+			continue
+		}
+
+		bitsPerSecond, err := resource.ParseQuantity(value)
+		if err != nil {
+			return garden.BandwidthLimits{}, fmt.Errorf("failed to parse annotation %s of pod %s: %w", key, pod.Name, err)
+		}
+
+		limits.RateInBytesPerSecond = uint64(bitsPerSecond.Value() / 8)
+		break
+	}
+
+	if value, ok := pod.Annotations[BandwidthBurstAnnotationKey]; ok {
+		burst, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return garden.BandwidthLimits{}, fmt.Errorf("failed to parse annotation %s of pod %s: %w", BandwidthBurstAnnotationKey, pod.Name, err)
+		}
+		limits.BurstRateInBytesPerSecond = burst
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,193 @@
+package k8sgarden
+
+import (
+	"fmt"
+	"net/netip"
+
+	"code.cloudfoundry.org/garden"
+	"code.cloudfoundry.org/lager/v3"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// NetOutLogAnnotationKey is set on network policies with at least one rule
+// that requests logging. NetworkPolicies have no logging of their own, CNIs
+// that support it can act on this annotation.
+const NetOutLogAnnotationKey = "cloudfoundry.org/netout-log"
+
+// newNetOutPolicy returns the egress network policy for the container with
+// the given handle. Without any rules it only allows DNS, as pods resolve
+// names through the cluster DNS rather than a resolver on the host.
+func newNetOutPolicy(handle, namespace string) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      handle,
+			Namespace: namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					HandleLabelKey: handle,
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: ptr.To(corev1.ProtocolUDP), Port: ptr.To(intstr.FromInt32(53))},
+						{Protocol: ptr.To(corev1.ProtocolTCP), Port: ptr.To(intstr.FromInt32(53))},
+					},
+				},
+			},
+		},
+	}
+}
+
+// addNetOutRules appends the garden rules to the egress rules of the policy.
+// ICMP rules can not be expressed in a network policy and are skipped, so that
+// ICMP traffic stays denied.
+func addNetOutRules(log lager.Logger, policy *networkingv1.NetworkPolicy, rules []garden.NetOutRule) error {
+	for _, rule := range rules {
+		if rule.Protocol == garden.ProtocolICMP || rule.Protocol == garden.ProtocolICMPv6 {
+			log.Info("skipping-unsupported-icmp-rule", lager.Data{"rule": rule})
+			continue
+		}
+
+		egressRule := networkingv1.NetworkPolicyEgressRule{}
+		for _, network := range rule.Networks {
+			cidrs, err := ipRangeToCIDRs(network)
+			if err != nil {
+				return err
+			}
+
+			for _, cidr := range cidrs {
+				egressRule.To = append(egressRule.To, networkingv1.NetworkPolicyPeer{
+					IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+				})
+			}
+		}
+
+		if rule.Protocol != garden.ProtocolAll {
+			protocol := corev1.ProtocolTCP
+			if rule.Protocol == garden.ProtocolUDP {
+				protocol = corev1.ProtocolUDP
+			}
+
+			if len(rule.Ports) == 0 {
+				egressRule.Ports = append(egressRule.Ports, networkingv1.NetworkPolicyPort{Protocol: ptr.To(protocol)})
+			}
+
+			for _, portRange := range rule.Ports {
+				port := networkingv1.NetworkPolicyPort{
+					Protocol: ptr.To(protocol),
+					Port:     ptr.To(intstr.FromInt32(int32(portRange.Start))),
+				}
+				if portRange.End > portRange.Start {
+					port.EndPort = ptr.To(int32(portRange.End))
+				}
+				egressRule.Ports = append(egressRule.Ports, port)
+			}
+		}
+
+		if rule.Log {
+			if policy.Annotations == nil {
+				policy.Annotations = map[string]string{}
+			}
+			policy.Annotations[NetOutLogAnnotationKey] = "true"
+		}
+
+		policy.Spec.Egress = append(policy.Spec.Egress, egressRule)
+	}
+
+	return nil
+}
+
+// applyNetOutRules adds the rules to the network policy of the container,
+// creating the policy if it does not exist yet.
+func applyNetOutRules(log lager.Logger, clnt ctrlclient.Client, d deadlines, handle, namespace string, rules []garden.NetOutRule) error {
+	ctx, cancel := d.apiContext()
+	defer cancel()
+
+	policy := newNetOutPolicy(handle, namespace)
+	_, err := controllerutil.CreateOrUpdate(ctx, clnt, policy, func() error {
+		return addNetOutRules(log, policy, rules)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply network policy: %w", err)
+	}
+
+	return nil
+}
+
+func deleteNetOutPolicy(clnt ctrlclient.Client, d deadlines, handle, namespace string) error {
+	ctx, cancel := d.apiContext()
+	defer cancel()
+
+	return ctrlclient.IgnoreNotFound(clnt.Delete(ctx, newNetOutPolicy(handle, namespace)))
+}
+
+// ipRangeToCIDRs returns the smallest list of CIDRs that exactly covers the
+// range. An empty range covers all addresses.
+func ipRangeToCIDRs(ipRange garden.IPRange) ([]string, error) {
+	if ipRange.Start == nil && ipRange.End == nil {
+		return []string{"0.0.0.0/0"}, nil
+	}
+
+	start, ok := netip.AddrFromSlice(ipRange.Start)
+	if !ok {
+		return nil, fmt.Errorf("invalid start address %q", ipRange.Start)
+	}
+	end := start
+	if ipRange.End != nil {
+		end, ok = netip.AddrFromSlice(ipRange.End)
+		if !ok {
+			return nil, fmt.Errorf("invalid end address %q", ipRange.End)
+		}
+	}
+	start, end = start.Unmap(), end.Unmap()
+
+	if start.BitLen() != end.BitLen() || end.Less(start) {
+		return nil, fmt.Errorf("invalid address range %s-%s", start, end)
+	}
+
+	var cidrs []string
+	for {
+		// grow the prefix as long as it starts at start and does not pass end
+		prefix := netip.PrefixFrom(start, start.BitLen())
+		for ones := start.BitLen() - 1; ones >= 0; ones-- {
+			candidate := netip.PrefixFrom(start, ones).Masked()
+			if candidate.Addr() != start || end.Less(lastAddr(candidate)) {
+				break
+			}
+			prefix = candidate
+		}
+		cidrs = append(cidrs, prefix.String())
+
+		last := lastAddr(prefix)
+		if last == end {
+			return cidrs, nil
+		}
+		start = last.Next()
+	}
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().As16()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	for i := len(addr) - 1; i >= 0 && hostBits > 0; i-- {
+		n := min(hostBits, 8)
+		addr[i] |= byte(1<<n - 1)
+		hostBits -= n
+	}
+
+	last := netip.AddrFrom16(addr)
+	if prefix.Addr().Is4() {
+		return last.Unmap()
+	}
+	return last
+}
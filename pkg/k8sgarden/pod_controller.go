@@ -0,0 +1,125 @@
+package k8sgarden
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/garden"
+	"code.cloudfoundry.org/lager/v3"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// TerminationReasonProperty records why the pod of a container
+	// terminated without the container being destroyed.
+	TerminationReasonProperty = "kubernetes.termination-reason"
+
+	// PodDeletedReason is the termination reason of containers whose pods
+	// were deleted by someone else, e.g. with kubectl.
+	PodDeletedReason = "PodDeleted"
+)
+
+// PodController keeps the containers of the garden client in line with their
+// pods. The status of the pods is kept current, and containers whose pods
+// were deleted or failed are marked as terminated, so that the executor sees
+// them as crashed.
+type PodController struct {
+	client *client
+	log    lager.Logger
+}
+
+// NewPodController returns the [PodController] for a garden client returned
+// by [NewClient].
+func NewPodController(gardenClient garden.Client) (*PodController, error) {
+	c, ok := gardenClient.(*client)
+	if !ok {
+		return nil, fmt.Errorf("garden client %T does not run containers in pods", gardenClient)
+	}
+
+	return &PodController{
+		client: c,
+		log:    c.logger.Session("pod-controller"),
+	}, nil
+}
+
+// SetupWithManager registers the controller with the manager, watching the
+// pods of the workloads namespace.
+func (r *PodController) SetupWithManager(mgr manager.Manager) error {
+	return builder.ControllerManagedBy(mgr).
+		Named("garden-containers").
+		For(&corev1.Pod{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj ctrlclient.Object) bool {
+			return obj.GetNamespace() == r.client.workloadsNamespace
+		}))).
+		Complete(r)
+}
+
+// Reconcile implements [reconcile.Reconciler].
+func (r *PodController) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	if req.Namespace != r.client.workloadsNamespace {
+		return reconcile.Result{}, nil
+	}
+
+	container, err := r.client.containers.Get(req.Name)
+	if err != nil {
+		// not a container of the client, or it is gone already
+		return reconcile.Result{}, nil
+	}
+
+	// pods of destroyed containers are expected to go away
+	if container.isDestroying() {
+		r.client.deletions.enqueue()
+		return reconcile.Result{}, nil
+	}
+
+	pod := &corev1.Pod{}
+	if err := r.client.k8sclient.Get(ctx, req.NamespacedName, pod); err != nil {
+		if ctrlclient.IgnoreNotFound(err) != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to get pod %s: %w", req.Name, err)
+		}
+
+		// there is no pod left to record the reason on
+		r.terminate(container, PodFailedError{Handle: req.Name, Reason: PodDeletedReason, Message: "pod was deleted"})
+		return reconcile.Result{}, nil
+	}
+
+	// a pod of the same name that the container does not know about
+	if pod.UID != container.currentPod().UID {
+		return reconcile.Result{}, nil
+	}
+
+	container.updatePodStatus(pod)
+
+	// pods that are still starting are watched by Create
+	if pod.Status.Phase == corev1.PodPending {
+		return reconcile.Result{}, nil
+	}
+
+	var failed PodFailedError
+	if !errors.As(podStartError(pod), &failed) {
+		return reconcile.Result{}, nil
+	}
+
+	r.terminate(container, failed)
+
+	// the reason is persisted on every reconcile of the failed pod, so that
+	// a failed patch is retried; it is a no-op once the pod records it
+	if err := container.persistProperties(TerminationReasonProperty); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to record termination reason of %s: %w", req.Name, err)
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func (r *PodController) terminate(container *container, reason PodFailedError) {
+	if !container.markTerminated(reason) {
+		return
+	}
+
+	r.log.Info("container-terminated", lager.Data{"handle": reason.Handle, "reason": reason.Reason, "message": reason.Message})
+}
@@ -0,0 +1,229 @@
+package k8sgarden
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// terminalWaitingReasons are the reasons of waiting containers that kubelet
+// does not recover from without a change to the pod.
+var terminalWaitingReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImageNeverPull":          true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+	"CrashLoopBackOff":           true,
+}
+
+const (
+	// OutOfMemoryEvent is the event of containers whose processes were killed
+	// for exceeding their memory limit, as emitted by Guardian.
+	OutOfMemoryEvent = "Out of memory"
+
+	// DiskLimitExceededEvent is the event of containers whose pods were
+	// evicted for exceeding their ephemeral storage limit. The run step of the
+	// executor only adds the out of memory event to the crash reason, this one
+	// is left to clients of the garden container info.
+	DiskLimitExceededEvent = "Disk limit exceeded"
+)
+
+// PodFailedError is returned by Create if the pod of the container can not
+// start, with the reason and message reported by Kubernetes.
+type PodFailedError struct {
+	Handle  string
+	Reason  string
+	Message string
+}
+
+func (e PodFailedError) Error() string {
+	return fmt.Sprintf("pod %s failed: %s: %s", e.Handle, e.Reason, e.Message)
+}
+
+// podStartError returns a [PodFailedError] if the pod can not start anymore.
+func podStartError(pod *corev1.Pod) error {
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		reason := pod.Status.Reason
+		if reason == "" {
+			reason = string(pod.Status.Phase)
+		}
+		return PodFailedError{Handle: pod.Name, Reason: reason, Message: pod.Status.Message}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil && terminalWaitingReasons[waiting.Reason] {
+			return PodFailedError{
+				Handle:  pod.Name,
+				Reason:  waiting.Reason,
+				Message: fmt.Sprintf("container %s: %s", status.Name, waiting.Message),
+			}
+		}
+
+		// containers of the pod are never restarted
+		if terminated := status.State.Terminated; terminated != nil && !isInitContainer(pod, status.Name) {
+			return PodFailedError{
+				Handle:  pod.Name,
+				Reason:  terminated.Reason,
+				Message: fmt.Sprintf("container %s exited with code %d: %s", status.Name, terminated.ExitCode, terminated.Message),
+			}
+		}
+	}
+
+	return nil
+}
+
+// podStopped reports whether the pod is done or any of its containers
+// terminated, which makes the garden container stopped.
+func podStopped(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return true
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podEvents returns the garden events of the pod: containers that were
+// OOM-killed, and evictions for exceeding the ephemeral storage limit. The
+// crash reason of evicted containers also names the eviction by the
+// TerminationReasonProperty.
+func podEvents(pod *corev1.Pod) []string {
+	events := []string{}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		for _, terminated := range []*corev1.ContainerStateTerminated{status.State.Terminated, status.LastTerminationState.Terminated} {
+			if terminated != nil && terminated.Reason == "OOMKilled" && !slices.Contains(events, OutOfMemoryEvent) {
+				events = append(events, OutOfMemoryEvent)
+			}
+		}
+	}
+
+	if pod.Status.Reason == "Evicted" && isDiskEviction(pod.Status.Message) {
+		events = append(events, DiskLimitExceededEvent)
+	}
+
+	return events
+}
+
+// isDiskEviction reports whether the eviction message of kubelet is about
+// ephemeral storage, e.g. "Pod ephemeral local storage usage exceeds the total
+// limit of containers 1Gi." or "Usage of EmptyDir volume "tmp" exceeds the
+// limit "1Gi".".
+func isDiskEviction(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "ephemeral") || strings.Contains(message, "emptydir")
+}
+
+func isInitContainer(pod *corev1.Pod, name string) bool {
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createPodAndWait creates the pod and waits until it is running, using the
+// events of the pod informer. It fails as soon as the pod can not start
+// anymore, rather than when the context is done. Deleting the pod is added to
+// the rollback once it was created.
+//
+// The given pod is not modified, it may be shared with the container already.
+// The returned pod is a copy of the created pod with the status of the running
+// pod.
+func createPodAndWait(ctx context.Context, log lager.Logger, clnt ctrlclient.Client, d deadlines, informer cache.Informer, pod *corev1.Pod, undo *rollback) (*corev1.Pod, error) {
+	// only the latest state of the pod is of interest
+	updates := make(chan *corev1.Pod, 1)
+	update := func(obj any) {
+		updated, ok := obj.(*corev1.Pod)
+		if !ok || updated.Name != pod.Name || updated.Namespace != pod.Namespace {
+			return
+		}
+
+		for {
+			select {
+			case updates <- updated:
+				return
+			default:
+			}
+
+			select {
+			case <-updates:
+			default:
+			}
+		}
+	}
+
+	deleted := make(chan struct{})
+	var deleteOnce sync.Once
+
+	// the handler is registered before the pod is created to not miss any event
+	registration, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj any) { update(obj) },
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if deletedPod, ok := obj.(*corev1.Pod); ok && deletedPod.Name == pod.Name && deletedPod.Namespace == pod.Namespace {
+				deleteOnce.Do(func() { close(deleted) })
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pod: %w", err)
+	}
+	defer func() {
+		if err := informer.RemoveEventHandler(registration); err != nil {
+			log.Error("failed-to-stop-watching-pod", err)
+		}
+	}()
+
+	created := pod.DeepCopy()
+	if err := clnt.Create(ctx, created); err != nil {
+		return nil, fmt.Errorf("failed to create pod: %w", err)
+	}
+	undo.add("delete-pod", func() error {
+		return deletePod(log, created, clnt, d, ctrlclient.GracePeriodSeconds(0))
+	})
+
+	current := created
+	for {
+		if err := podStartError(current); err != nil {
+			log.Error("pod-failed-to-start", err)
+			return nil, err
+		}
+
+		if current.Status.Phase == corev1.PodRunning {
+			running := created.DeepCopy()
+			current.Status.DeepCopyInto(&running.Status)
+			return running, nil
+		}
+		log.Info("waiting-for-pod-to-be-running", lager.Data{"pod-name": pod.Name, "current-phase": current.Status.Phase})
+
+		select {
+		case current = <-updates:
+		case <-deleted:
+			return nil, fmt.Errorf("pod %s was deleted before it was running", pod.Name)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for pod to be running, last phase %s", current.Status.Phase)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package k8sgarden
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/commandrunner"
+	"code.cloudfoundry.org/garden"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// NetInAnnotationKey holds the port mappings added with NetIn after the
+	// pod was created, as pod host ports can not be changed on a running pod.
+	NetInAnnotationKey = "cloudfoundry.org/net-in"
+
+	netInChain = "K8S-GARDEN-NETIN"
+
+	// the rep runs with hostPID, so the network namespace of pid 1 is the one
+	// of the node
+	nodeNetNS = "/proc/1/ns/net"
+)
+
+type PortForwarder interface {
+	// Setup prepares the node for forwarding and drops all forwards left from
+	// a previous run.
+	Setup() error
+	Forward(handle, podIP string, mapping garden.PortMapping) error
+	Remove(handle, podIP string, mapping garden.PortMapping) error
+}
+
+type portForwarder struct {
+	runner commandrunner.CommandRunner
+	mu     sync.Mutex
+}
+
+// NewPortForwarder returns a [PortForwarder] that forwards host ports of the
+// node to pods with iptables DNAT rules.
+func NewPortForwarder(runner commandrunner.CommandRunner) PortForwarder {
+	return &portForwarder{runner: runner}
+}
+
+func (f *portForwarder) Setup() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.iptables("-t", "nat", "-S", netInChain); err != nil {
+		if err := f.iptables("-t", "nat", "-N", netInChain); err != nil {
+			return err
+		}
+	}
+
+	// both forwarded and locally generated traffic to an address of the node
+	jump := []string{"-m", "addrtype", "--dst-type", "LOCAL", "-j", netInChain}
+	for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+		if err := f.iptables(append([]string{"-t", "nat", "-C", chain}, jump...)...); err == nil {
+			continue
+		}
+
+		if err := f.iptables(append([]string{"-t", "nat", "-I", chain}, jump...)...); err != nil {
+			return err
+		}
+	}
+
+	return f.iptables("-t", "nat", "-F", netInChain)
+}
+
+func (f *portForwarder) Forward(handle, podIP string, mapping garden.PortMapping) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.iptables(append([]string{"-t", "nat", "-A", netInChain}, dnatRule(handle, podIP, mapping)...)...)
+}
+
+// Remove deletes the forward, forwards that do not exist are ignored.
+func (f *portForwarder) Remove(handle, podIP string, mapping garden.PortMapping) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rule := dnatRule(handle, podIP, mapping)
+	if err := f.iptables(append([]string{"-t", "nat", "-C", netInChain}, rule...)...); err != nil {
+		return nil
+	}
+
+	return f.iptables(append([]string{"-t", "nat", "-D", netInChain}, rule...)...)
+}
+
+func (f *portForwarder) iptables(args ...string) error {
+	cmd := exec.Command("nsenter", append([]string{"--net=" + nodeNetNS, "--", "iptables", "-w"}, args...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	if err := f.runner.Run(cmd); err != nil {
+		return fmt.Errorf("iptables %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func dnatRule(handle, podIP string, mapping garden.PortMapping) []string {
+	return []string{
+		"-p", "tcp",
+		"--dport", strconv.FormatUint(uint64(mapping.HostPort), 10),
+		"-m", "comment", "--comment", handle,
+		"-j", "DNAT",
+		"--to-destination", net.JoinHostPort(podIP, strconv.FormatUint(uint64(mapping.ContainerPort), 10)),
+	}
+}
+
+// netInMappings returns the port mappings added to the pod with NetIn.
+func netInMappings(pod *corev1.Pod) ([]garden.PortMapping, error) {
+	value, ok := pod.Annotations[NetInAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var mappings []garden.PortMapping
+	if err := json.Unmarshal([]byte(value), &mappings); err != nil {
+		return nil, fmt.Errorf("failed to decode annotation %s of pod %s: %w", NetInAnnotationKey, pod.Name, err)
+	}
+
+	return mappings, nil
+}
+
+// restoreForwards programs the forwards of all pods again, after Setup
+// dropped the ones left from the previous run.
+func restoreForwards(forwarder PortForwarder, pods []*corev1.Pod) error {
+	if err := forwarder.Setup(); err != nil {
+		return fmt.Errorf("failed to set up port forwarding: %w", err)
+	}
+
+	for _, pod := range pods {
+		mappings, err := netInMappings(pod)
+		if err != nil {
+			return err
+		}
+
+		for _, mapping := range mappings {
+			if err := forwarder.Forward(pod.Name, pod.Status.PodIP, mapping); err != nil {
+				return fmt.Errorf("failed to restore port forwarding for %s: %w", pod.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,46 +2,270 @@ package k8sgarden
 
 import (
 	"fmt"
-	"math"
 	"sync"
+
+	loggingclient "code.cloudfoundry.org/diego-logging-client"
+	corev1 "k8s.io/api/core/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-const startPort uint32 = 62000
+const (
+	// nodeNameField selects the pods scheduled to a node.
+	nodeNameField = "spec.nodeName"
+
+	HostPortsAllocatedMetric = "HostPortsAllocated"
+	HostPortsFreeMetric      = "HostPortsFree"
+)
+
+// PortRangeExhaustedError is returned when all host ports of the range are
+// allocated.
+type PortRangeExhaustedError struct {
+	Start uint32
+	End   uint32
+}
+
+func (e PortRangeExhaustedError) Error() string {
+	return fmt.Sprintf("no available host ports in range %d-%d", e.Start, e.End)
+}
+
+// PortInUseError is returned when a host port that was asked for explicitly
+// is allocated already or bound on the node.
+type PortInUseError struct {
+	Port uint32
+}
+
+func (e PortInUseError) Error() string {
+	return fmt.Sprintf("host port %d is already in use", e.Port)
+}
 
 type PortManager interface {
 	Next() (uint32, error)
+	// Acquire allocates the given port, failing with a [PortInUseError] if
+	// it is allocated already or bound on the node.
+	Acquire(port uint32) error
+	// Reserve marks the port as allocated, for ports that were handed out
+	// before the rep restarted.
+	Reserve(port uint32)
 	Release(port uint32)
+	// Free returns the number of ports of the range that are neither
+	// allocated nor bound on the node.
+	Free() (int, error)
 }
 
+// HostPortsFunc returns the host ports that are bound on the node by pods the
+// port manager does not know about.
+type HostPortsFunc func() (map[uint32]struct{}, error)
+
 type portManager struct {
-	allocated map[uint32]struct{}
-	mu        sync.Mutex
+	start        uint32
+	end          uint32
+	allocated    map[uint32]struct{}
+	hostPorts    HostPortsFunc
+	metronClient loggingclient.IngressClient
+	mu           sync.Mutex
 }
 
-func newPortManager() *portManager {
+// NewPortManager returns a [PortManager] handing out the host ports from start
+// to end, both inclusive. Ports returned by hostPorts are skipped, hostPorts
+// may be nil. The number of allocated and free ports is emitted with the
+// metron client after every change, if it is not nil.
+func NewPortManager(start, end uint32, hostPorts HostPortsFunc, metronClient loggingclient.IngressClient) PortManager {
 	return &portManager{
-		allocated: make(map[uint32]struct{}),
-		mu:        sync.Mutex{},
+		start:        start,
+		end:          end,
+		allocated:    make(map[uint32]struct{}),
+		hostPorts:    hostPorts,
+		metronClient: metronClient,
+		mu:           sync.Mutex{},
 	}
 }
 
+func (p *portManager) Reserve(port uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.allocated[port] = struct{}{}
+	p.emitMetrics()
+}
+
+func (p *portManager) Acquire(port uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, allocated := p.allocated[port]; allocated {
+		return PortInUseError{Port: port}
+	}
+
+	bound, err := p.boundPorts()
+	if err != nil {
+		return err
+	}
+	if _, ok := bound[port]; ok {
+		return PortInUseError{Port: port}
+	}
+
+	p.allocated[port] = struct{}{}
+	p.emitMetrics()
+	return nil
+}
+
 func (p *portManager) Release(port uint32) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	delete(p.allocated, port)
+	p.emitMetrics()
 }
 
 func (p *portManager) Next() (uint32, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for port := startPort; port < math.MaxUint16; port++ {
-		if _, allocated := p.allocated[port]; !allocated {
-			p.allocated[port] = struct{}{}
-			return port, nil
+	bound, err := p.boundPorts()
+	if err != nil {
+		return 0, err
+	}
+
+	// the loop variable is wider than the ports, so that it can not overflow
+	// at the end of the range
+	for port := uint64(p.start); port <= uint64(p.end); port++ {
+		if _, allocated := p.allocated[uint32(port)]; allocated {
+			continue
+		}
+		if _, ok := bound[uint32(port)]; ok {
+			continue
+		}
+
+		p.allocated[uint32(port)] = struct{}{}
+		p.emitMetrics()
+		return uint32(port), nil
+	}
+
+	return 0, PortRangeExhaustedError{Start: p.start, End: p.end}
+}
+
+func (p *portManager) Free() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bound, err := p.boundPorts()
+	if err != nil {
+		return 0, err
+	}
+
+	free := 0
+	for port := uint64(p.start); port <= uint64(p.end); port++ {
+		if _, allocated := p.allocated[uint32(port)]; allocated {
+			continue
+		}
+		if _, ok := bound[uint32(port)]; ok {
+			continue
+		}
+		free++
+	}
+
+	return free, nil
+}
+
+// boundPorts returns the host ports bound on the node by other pods. It must
+// be called with the lock held.
+func (p *portManager) boundPorts() (map[uint32]struct{}, error) {
+	if p.hostPorts == nil {
+		return map[uint32]struct{}{}, nil
+	}
+
+	bound, err := p.hostPorts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host ports of the node: %w", err)
+	}
+
+	return bound, nil
+}
+
+// emitMetrics sends the allocated and free ports of the range. Ports that
+// were reserved outside of the range, e.g. before the range was changed, are
+// not counted. It must be called with the lock held.
+func (p *portManager) emitMetrics() {
+	if p.metronClient == nil {
+		return
+	}
+
+	allocated := 0
+	for port := range p.allocated {
+		if port >= p.start && port <= p.end {
+			allocated++
+		}
+	}
+	free := int(p.end-p.start) + 1 - allocated
+
+	// failing to emit metrics must not fail the allocation
+	_ = p.metronClient.SendMetric(HostPortsAllocatedMetric, allocated)
+	_ = p.metronClient.SendMetric(HostPortsFreeMetric, free)
+}
+
+// nodeHostPorts returns a [HostPortsFunc] listing the host ports of all pods
+// on the node, including pods of other namespaces like DaemonSets. The pods
+// are listed from the cache of the reader, which holds all pods of the node.
+// Pods that are done do not hold their ports anymore.
+func nodeHostPorts(reader ctrlclient.Reader, d deadlines, nodeName string) HostPortsFunc {
+	return func() (map[uint32]struct{}, error) {
+		ctx, cancel := d.apiContext()
+		defer cancel()
+
+		podList := &corev1.PodList{}
+		if err := reader.List(ctx, podList, ctrlclient.MatchingFields{nodeNameField: nodeName}); err != nil {
+			return nil, fmt.Errorf("failed to list pods of node %s: %w", nodeName, err)
+		}
+
+		ports := map[uint32]struct{}{}
+		for _, pod := range podList.Items {
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+
+			for _, port := range podHostPorts(&pod) {
+				ports[port] = struct{}{}
+			}
+		}
+
+		return ports, nil
+	}
+}
+
+// podHostPorts returns the host ports bound by the pod. Pods in the host
+// network bind their container ports on the node.
+func podHostPorts(pod *corev1.Pod) []uint32 {
+	var ports []uint32
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			switch {
+			case port.HostPort != 0:
+				ports = append(ports, uint32(port.HostPort))
+			case pod.Spec.HostNetwork:
+				ports = append(ports, uint32(port.ContainerPort))
+			}
+		}
+	}
+
+	return ports
+}
+
+// restorePorts reserves the host ports of the pods left from a previous run,
+// including the ones added with NetIn.
+func restorePorts(portManager PortManager, pods []*corev1.Pod) error {
+	for _, pod := range pods {
+		for _, port := range podHostPorts(pod) {
+			portManager.Reserve(port)
+		}
+
+		mappings, err := netInMappings(pod)
+		if err != nil {
+			return err
+		}
+		for _, mapping := range mappings {
+			portManager.Reserve(mapping.HostPort)
 		}
 	}
 
-	return 0, fmt.Errorf("no available ports")
+	return nil
 }
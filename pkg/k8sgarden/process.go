@@ -3,23 +3,48 @@ package k8sgarden
 import (
 	"context"
 	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"syscall"
 
 	"code.cloudfoundry.org/garden"
 	"code.cloudfoundry.org/lager/v3"
 	ctrdclient "github.com/containerd/containerd/v2/client"
 	"github.com/containerd/containerd/v2/pkg/cio"
+	"github.com/containerd/errdefs"
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
+// fifoDir is where the fifos of exec'd processes are created. It lives on the
+// host so that processes can be attached to again after a rep restart.
+const fifoDir = "/var/lib/rep/containerd_fifo"
+
+var errProcessNotStarted = errors.New("process has not been started")
+
 type process struct {
 	log     lager.Logger
 	id      string
 	io      garden.ProcessIO
 	process ctrdclient.Process
+	mu      sync.Mutex
+
+	// stdout and stderr are read from the fifos once and written to every
+	// attached process IO
+	stdout *processOutput
+	stderr *processOutput
 
-	task ctrdclient.Task
-	spec *specs.Process
+	task     ctrdclient.Task
+	spec     *specs.Process
+	attached bool
+	onExit   func()
+
+	// exited is closed once the process has exited and its exit status has
+	// been stored in exitCode and exitErr
+	exited   chan struct{}
+	exitCode int
+	exitErr  error
 }
 
 type Process interface {
@@ -28,22 +53,85 @@ type Process interface {
 	Task() ctrdclient.Task
 }
 
+// NewProcess execs the process in the task and starts it.
 func NewProcess(
 	log lager.Logger,
 	id string,
 	spec *specs.Process,
 	io garden.ProcessIO,
 	task ctrdclient.Task,
-) Process {
+) (Process, error) {
+	proc := newProcess(log, id, spec, io, task, nil)
+	if err := proc.start(); err != nil {
+		return nil, err
+	}
+
+	return proc, nil
+}
+
+func newProcess(
+	log lager.Logger,
+	id string,
+	spec *specs.Process,
+	io garden.ProcessIO,
+	task ctrdclient.Task,
+	onExit func(),
+) *process {
 	return &process{
-		log:  log,
-		id:   id,
-		spec: spec,
-		io:   io,
-		task: task,
+		log:    log,
+		id:     id,
+		spec:   spec,
+		io:     io,
+		task:   task,
+		onExit: onExit,
+		stdout: newProcessOutput(io.Stdout),
+		stderr: newProcessOutput(io.Stderr),
+		exited: make(chan struct{}),
 	}
 }
 
+// attachedProcess returns a process for an exec process that is already
+// running in the task, e.g. one that was started before the rep restarted.
+// Its IO is re-opened through its fifos by load.
+func attachedProcess(
+	log lager.Logger,
+	id string,
+	pio garden.ProcessIO,
+	task ctrdclient.Task,
+	onExit func(),
+	load func(cio.Attach) (ctrdclient.Process, error),
+) (*process, error) {
+	proc := &process{
+		log:      log,
+		id:       id,
+		io:       pio,
+		task:     task,
+		attached: true,
+		onExit:   onExit,
+		stdout:   newProcessOutput(pio.Stdout),
+		stderr:   newProcessOutput(pio.Stderr),
+		exited:   make(chan struct{}),
+	}
+
+	ctrdProcess, err := load(cio.NewAttach(cio.WithStreams(pio.Stdin, proc.stdout, proc.stderr)))
+	if err != nil {
+		return nil, err
+	}
+
+	statusChan, err := ctrdProcess.Wait(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	proc.mu.Lock()
+	proc.process = ctrdProcess
+	proc.mu.Unlock()
+
+	go proc.waitForExit(ctrdProcess, statusChan)
+
+	return proc, nil
+}
+
 // ID implements [garden.Process].
 func (p *process) ID() string {
 	return p.id
@@ -56,42 +144,135 @@ func (p *process) Signal(signal garden.Signal) error {
 		s = syscall.SIGKILL
 	}
 
-	p.log.Info("signaling-process", lager.Data{"signal": s, "pid": p.process.Pid()})
-	return p.process.Kill(context.Background(), s)
+	p.mu.Lock()
+	ctrdProcess := p.process
+	p.mu.Unlock()
+
+	if ctrdProcess == nil {
+		return errProcessNotStarted
+	}
+
+	p.log.Info("signaling-process", lager.Data{"signal": s, "pid": ctrdProcess.Pid()})
+	return ctrdProcess.Kill(context.Background(), s)
 }
 
-// Wait implements [garden.Process].
+// Wait implements [garden.Process]. It can be called any number of times and
+// returns the same exit status to all callers.
 func (p *process) Wait() (int, error) {
 	p.log.Info("waiting-for-process-to-exit")
 	defer p.log.Info("process-exited")
-	var err error
 
-	p.process, err = p.task.Exec(context.Background(), p.id, p.spec, cio.NewCreator(cio.WithStreams(p.io.Stdin, p.io.Stdout, p.io.Stderr), cio.WithFIFODir("/var/lib/rep/containerd_fifo")))
-	if err != nil {
-		return -1, err
+	<-p.exited
+	return p.exitCode, p.exitErr
+}
+
+// start execs the process in the task and waits for it to exit in the
+// background. In terminal mode stdout and stderr of the process are both
+// written to the stdout of the process IO, as with garden.
+func (p *process) start() error {
+	opts := []cio.Opt{cio.WithStreams(p.io.Stdin, p.stdout, p.stderr), cio.WithFIFODir(fifoDir)}
+	if p.spec.Terminal {
+		opts = append(opts, cio.WithTerminal)
 	}
 
-	if err := p.process.Start(context.Background()); err != nil {
-		return -1, err
+	ctrdProcess, err := p.task.Exec(context.Background(), p.id, p.spec, cio.NewCreator(opts...))
+	if err != nil {
+		return err
 	}
 
-	statusChan, err := p.process.Wait(context.Background())
+	// wait before starting to not miss the exit of short-lived processes
+	statusChan, err := ctrdProcess.Wait(context.Background())
 	if err != nil {
-		return -1, err
+		_, deleteErr := ctrdProcess.Delete(context.Background())
+		return errors.Join(err, deleteErr)
+	}
+
+	if err := ctrdProcess.Start(context.Background()); err != nil {
+		_, deleteErr := ctrdProcess.Delete(context.Background())
+		return errors.Join(err, deleteErr)
+	}
+
+	if p.spec.Terminal && p.spec.ConsoleSize != nil {
+		if err := ctrdProcess.Resize(context.Background(), uint32(p.spec.ConsoleSize.Width), uint32(p.spec.ConsoleSize.Height)); err != nil {
+			p.log.Error("failed-to-set-initial-window-size", err)
+		}
+	}
+
+	p.mu.Lock()
+	p.process = ctrdProcess
+	p.mu.Unlock()
+
+	go p.waitForExit(ctrdProcess, statusChan)
+	return nil
+}
+
+// waitForExit stores the exit status of the process once it exited and its
+// IO is drained, then cleans up the process.
+func (p *process) waitForExit(ctrdProcess ctrdclient.Process, statusChan <-chan ctrdclient.ExitStatus) {
+	defer close(p.exited)
+	if p.onExit != nil {
+		defer p.onExit()
 	}
+
 	exitStatus := <-statusChan
 
 	// wait for io to also catch daemon processes
 	var closeErr error
-	if io := p.process.IO(); io != nil {
+	var fifos cio.Config
+	if io := ctrdProcess.IO(); io != nil {
 		p.log.Info("waiting-for-io-to-finish")
 		io.Wait()
 		p.log.Info("io-finished")
 		closeErr = io.Close()
+		fifos = io.Config()
+	}
+
+	// another handle to the same process may have deleted it already
+	_, err := ctrdProcess.Delete(context.Background())
+	if errdefs.IsNotFound(err) {
+		err = nil
+	}
+
+	// the fifos of attached processes are not removed by closing their IO
+	if p.attached {
+		closeErr = errors.Join(closeErr, removeFIFOs(fifos))
+	}
+
+	p.exitCode = int(exitStatus.ExitCode())
+	p.exitErr = errors.Join(exitStatus.Error(), err, closeErr)
+}
+
+// running returns the containerd process if it has been started and has not
+// exited yet.
+func (p *process) running(ctx context.Context) (ctrdclient.Process, bool) {
+	p.mu.Lock()
+	ctrdProcess := p.process
+	p.mu.Unlock()
+
+	if ctrdProcess == nil {
+		return nil, false
 	}
-	_, err = p.process.Delete(context.Background())
 
-	return int(exitStatus.ExitCode()), errors.Join(exitStatus.Error(), err, closeErr)
+	select {
+	case <-p.exited:
+		return nil, false
+	default:
+	}
+
+	status, err := ctrdProcess.Status(ctx)
+	if err != nil {
+		return nil, false
+	}
+
+	return ctrdProcess, status.Status != ctrdclient.Stopped
+}
+
+// attach writes the output of the process to the IO as well. The stdin of
+// the process stays connected to the IO it was started or first attached
+// with.
+func (p *process) attach(pio garden.ProcessIO) {
+	p.stdout.add(pio.Stdout)
+	p.stderr.add(pio.Stderr)
 }
 
 func (p *process) Spec() *specs.Process {
@@ -102,6 +283,81 @@ func (p *process) Task() ctrdclient.Task {
 	return p.task
 }
 
-func (p *process) SetTTY(garden.TTYSpec) error {
-	panic("unimplemented")
+// SetTTY implements [garden.Process].
+func (p *process) SetTTY(tty garden.TTYSpec) error {
+	if tty.WindowSize == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	ctrdProcess := p.process
+	p.mu.Unlock()
+
+	if ctrdProcess == nil {
+		return errProcessNotStarted
+	}
+
+	p.log.Info("resizing-tty", lager.Data{"window-size": tty.WindowSize})
+	return ctrdProcess.Resize(context.Background(), uint32(tty.WindowSize.Columns), uint32(tty.WindowSize.Rows))
+}
+
+// removeFIFOs removes the directory holding the fifos of a process, as created
+// by [cio.NewFIFOSetInDir].
+func removeFIFOs(fifos cio.Config) error {
+	for _, path := range []string{fifos.Stdin, fifos.Stdout, fifos.Stderr} {
+		if path == "" {
+			continue
+		}
+
+		dir := filepath.Dir(path)
+		if filepath.Dir(dir) != fifoDir {
+			return nil
+		}
+
+		return os.RemoveAll(dir)
+	}
+
+	return nil
+}
+
+// processOutput writes the output of a process to all attached writers.
+// Writers that fail are dropped, so that one attacher going away does not
+// stop the output for the others.
+type processOutput struct {
+	mu      sync.Mutex
+	writers []io.Writer
+}
+
+func newProcessOutput(w io.Writer) *processOutput {
+	out := &processOutput{}
+	out.add(w)
+	return out
+}
+
+func (o *processOutput) add(w io.Writer) {
+	if w == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.writers = append(o.writers, w)
+}
+
+// Write implements [io.Writer]. It never fails, as containerd stops copying
+// the output of the process on errors.
+func (o *processOutput) Write(data []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	writers := o.writers[:0]
+	for _, w := range o.writers {
+		if _, err := w.Write(data); err == nil {
+			writers = append(writers, w)
+		}
+	}
+	o.writers = writers
+
+	return len(data), nil
 }
@@ -1,8 +1,10 @@
 package k8sgarden_test
 
 import (
+	"errors"
 	"io"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -55,13 +57,18 @@ var _ = Describe("Process", func() {
 			Stderr: io.Discard,
 		}
 
-		testProcess = k8sgarden.NewProcess(
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		testProcess, err = k8sgarden.NewProcess(
 			logger,
 			"test-process",
 			processSpec,
 			processIO,
 			fakeTask,
 		)
+		Expect(err).NotTo(HaveOccurred())
 	})
 
 	Describe("ID", func() {
@@ -70,6 +77,26 @@ var _ = Describe("Process", func() {
 		})
 	})
 
+	Describe("NewProcess", func() {
+		It("execs and starts the process right away", func() {
+			Expect(fakeTask.ExecCallCount()).To(Equal(1))
+			_, id, spec, _ := fakeTask.ExecArgsForCall(0)
+			Expect(id).To(Equal("test-process"))
+			Expect(spec).To(Equal(processSpec))
+
+			Expect(fakeProcess.WaitCallCount()).To(Equal(1))
+			Expect(fakeProcess.StartCallCount()).To(Equal(1))
+		})
+
+		It("returns an error and cleans up when the process fails to start", func() {
+			fakeProcess.StartReturns(errors.New("start failed"))
+
+			_, err := k8sgarden.NewProcess(logger, "failing-process", processSpec, processIO, fakeTask)
+			Expect(err).To(MatchError(ContainSubstring("start failed")))
+			Expect(fakeProcess.DeleteCallCount()).To(Equal(1))
+		})
+	})
+
 	Describe("Wait and Signal", func() {
 		It("starts the process and sends signals correctly", func() {
 			exitStatus := ctrdclient.NewExitStatus(42, time.Now(), nil)
@@ -88,5 +115,72 @@ var _ = Describe("Process", func() {
 			_, signal, _ = fakeProcess.KillArgsForCall(1)
 			Expect(signal).To(Equal(syscall.SIGKILL))
 		})
+
+		It("can signal the process before anyone waits for it", func() {
+			Expect(testProcess.Signal(garden.SignalTerminate)).To(Succeed())
+			Expect(fakeProcess.KillCallCount()).To(Equal(1))
+		})
+
+		It("returns the same exit status to every caller without exec'ing again", func() {
+			exitChan <- *ctrdclient.NewExitStatus(3, time.Now(), nil)
+
+			var wg sync.WaitGroup
+			for range 5 {
+				wg.Go(func() {
+					defer GinkgoRecover()
+
+					exitCode, err := testProcess.Wait()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(exitCode).To(Equal(3))
+				})
+			}
+			wg.Wait()
+
+			exitCode, err := testProcess.Wait()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exitCode).To(Equal(3))
+
+			Expect(fakeTask.ExecCallCount()).To(Equal(1))
+			Expect(fakeProcess.DeleteCallCount()).To(Equal(1))
+		})
+
+		It("blocks until the process exits", func() {
+			exited := make(chan struct{})
+			go func() {
+				defer close(exited)
+				_, _ = testProcess.Wait()
+			}()
+
+			Consistently(exited).ShouldNot(BeClosed())
+			exitChan <- *ctrdclient.NewExitStatus(0, time.Now(), nil)
+			Eventually(exited).Should(BeClosed())
+		})
+	})
+
+	Describe("SetTTY", func() {
+		BeforeEach(func() {
+			processSpec.Terminal = true
+			processSpec.ConsoleSize = &specs.Box{Width: 80, Height: 24}
+		})
+
+		It("applies the initial window size when the process starts", func() {
+			Expect(fakeProcess.ResizeCallCount()).To(Equal(1))
+			_, width, height := fakeProcess.ResizeArgsForCall(0)
+			Expect(width).To(Equal(uint32(80)))
+			Expect(height).To(Equal(uint32(24)))
+		})
+
+		It("resizes the terminal of the process", func() {
+			Expect(testProcess.SetTTY(garden.TTYSpec{WindowSize: &garden.WindowSize{Columns: 120, Rows: 40}})).To(Succeed())
+			Expect(fakeProcess.ResizeCallCount()).To(Equal(2))
+			_, width, height := fakeProcess.ResizeArgsForCall(1)
+			Expect(width).To(Equal(uint32(120)))
+			Expect(height).To(Equal(uint32(40)))
+		})
+
+		It("ignores specs without a window size", func() {
+			Expect(testProcess.SetTTY(garden.TTYSpec{})).To(Succeed())
+			Expect(fakeProcess.ResizeCallCount()).To(Equal(1))
+		})
 	})
 })
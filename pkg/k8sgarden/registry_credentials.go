@@ -0,0 +1,142 @@
+package k8sgarden
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	corev1 "k8s.io/api/core/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// dockerHubHosts are the names under which credentials for Docker Hub are
+// stored in docker config files, and the host images are pulled from.
+var dockerHubHosts = []string{"docker.io", "index.docker.io", "registry-1.docker.io"}
+
+// RegistryCredentials looks up the credentials of registries in the
+// kubernetes.io/dockerconfigjson secrets of the workloads namespace. They are
+// used for pulls of images without credentials of their own.
+type RegistryCredentials struct {
+	k8sclient   ctrlclient.Client
+	deadlines   deadlines
+	log         lager.Logger
+	namespace   string
+	secretNames []string
+}
+
+// NewRegistryCredentials returns the credentials of the secrets with the
+// given names in the namespace. The secrets are read on every lookup, so that
+// changes are picked up right away.
+func NewRegistryCredentials(logger lager.Logger, k8sclient ctrlclient.Client, clk clock.Clock, timeouts Timeouts, namespace string, secretNames []string) *RegistryCredentials {
+	return &RegistryCredentials{
+		k8sclient:   k8sclient,
+		deadlines:   newDeadlines(clk, timeouts),
+		log:         logger,
+		namespace:   namespace,
+		secretNames: secretNames,
+	}
+}
+
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// Lookup returns the username and password of the first secret with an entry
+// for the registry host, or empty credentials if there is none. Secrets that
+// can not be read are skipped, so that the image is pulled anonymously.
+func (r *RegistryCredentials) Lookup(host string) (string, string, error) {
+	for _, name := range r.secretNames {
+		auths, err := r.auths(name)
+		if err != nil {
+			r.log.Error("failed-to-read-image-pull-secret", err, lager.Data{"secret": name})
+			continue
+		}
+
+		for server, entry := range auths {
+			if !registryHostMatches(server, host) {
+				continue
+			}
+
+			username, password, err := entry.credentials()
+			if err != nil {
+				r.log.Error("failed-to-read-image-pull-secret", err, lager.Data{"secret": name, "server": server})
+				continue
+			}
+
+			return username, password, nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// auths returns the entries of the docker config of the secret.
+func (r *RegistryCredentials) auths(name string) (map[string]dockerConfigEntry, error) {
+	ctx, cancel := r.deadlines.apiContext()
+	defer cancel()
+
+	secret := &corev1.Secret{}
+	if err := r.k8sclient.Get(ctx, ctrlclient.ObjectKey{Name: name, Namespace: r.namespace}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %w", name, err)
+	}
+
+	if secret.Type != corev1.SecretTypeDockerConfigJson {
+		return nil, fmt.Errorf("secret %s has type %s, not %s", name, secret.Type, corev1.SecretTypeDockerConfigJson)
+	}
+
+	config := dockerConfigJSON{}
+	if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+		return nil, fmt.Errorf("failed to parse docker config of secret %s: %w", name, err)
+	}
+
+	return config.Auths, nil
+}
+
+// credentials returns the username and password of the entry, which are
+// either given as such or base64-encoded in auth.
+func (e dockerConfigEntry) credentials() (string, string, error) {
+	if e.Username != "" || e.Password != "" {
+		return e.Username, e.Password, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode auth: %w", err)
+	}
+
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", errors.New("auth is not of the form username:password")
+	}
+
+	return username, password, nil
+}
+
+// registryHostMatches reports whether the server of a docker config entry,
+// e.g. "https://index.docker.io/v1/" or "registry.example.com:5000", is the
+// registry host.
+func registryHostMatches(server, host string) bool {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server, _, _ = strings.Cut(server, "/")
+
+	if server == host {
+		return true
+	}
+
+	return isDockerHub(server) && isDockerHub(host)
+}
+
+func isDockerHub(host string) bool {
+	return slices.Contains(dockerHubHosts, host)
+}
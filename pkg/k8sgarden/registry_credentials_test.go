@@ -0,0 +1,79 @@
+package k8sgarden_test
+
+import (
+	"encoding/base64"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/k8s-garden-client/pkg/k8sgarden"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("RegistryCredentials", func() {
+	var (
+		secrets     []*corev1.Secret
+		credentials *k8sgarden.RegistryCredentials
+	)
+
+	dockerConfigSecret := func(name, config string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "cf-workloads"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(config)},
+		}
+	}
+
+	BeforeEach(func() {
+		auth := base64.StdEncoding.EncodeToString([]byte("hub-user:hub-password"))
+		secrets = []*corev1.Secret{
+			dockerConfigSecret("artifactory", `{"auths": {"artifactory.example.com:8443": {"username": "artifactory-user", "password": "artifactory-password"}}}`),
+			dockerConfigSecret("docker-hub", `{"auths": {"https://index.docker.io/v1/": {"auth": "`+auth+`"}, "artifactory.example.com:8443": {"username": "other-user", "password": "other-password"}}}`),
+			dockerConfigSecret("invalid", `{`),
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "cf-workloads"},
+				Type:       corev1.SecretTypeOpaque,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		builder := fake.NewClientBuilder()
+		for _, secret := range secrets {
+			builder = builder.WithObjects(secret)
+		}
+
+		credentials = k8sgarden.NewRegistryCredentials(
+			lagertest.NewTestLogger("registry-credentials"),
+			builder.Build(),
+			clock.NewClock(),
+			k8sgarden.Config{}.Timeouts(),
+			"cf-workloads",
+			[]string{"missing", "invalid", "opaque", "artifactory", "docker-hub"},
+		)
+	})
+
+	It("returns the credentials of the first secret with an entry for the host", func() {
+		username, password, err := credentials.Lookup("artifactory.example.com:8443")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(username).To(Equal("artifactory-user"))
+		Expect(password).To(Equal("artifactory-password"))
+	})
+
+	It("decodes the auth of entries and matches all names of Docker Hub", func() {
+		username, password, err := credentials.Lookup("registry-1.docker.io")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(username).To(Equal("hub-user"))
+		Expect(password).To(Equal("hub-password"))
+	})
+
+	It("returns empty credentials for other hosts", func() {
+		username, password, err := credentials.Lookup("artifactory.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(username).To(BeEmpty())
+		Expect(password).To(BeEmpty())
+	})
+})
@@ -0,0 +1,40 @@
+package k8sgarden
+
+import (
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// rollback collects the steps undoing the side effects of an operation, so
+// that they can be undone in reverse order if the operation fails.
+type rollback struct {
+	log   lager.Logger
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	name string
+	undo func() error
+}
+
+func newRollback(log lager.Logger) *rollback {
+	return &rollback{log: log}
+}
+
+// add registers the step undoing a side effect that just took place.
+func (r *rollback) add(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{name: name, undo: undo})
+}
+
+// run undoes all side effects in reverse order. Failing steps are logged and
+// do not stop the remaining ones.
+func (r *rollback) run() {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		if err := step.undo(); err != nil {
+			r.log.Error("failed-to-roll-back", err, lager.Data{"step": step.name})
+			continue
+		}
+		r.log.Info("rolled-back", lager.Data{"step": step.name})
+	}
+	r.steps = nil
+}
@@ -0,0 +1,240 @@
+package k8sgarden
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/garden"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// PropertiesAnnotationKey holds the garden properties of the container,
+	// except for the sensitive ones, which are kept in the state secret.
+	PropertiesAnnotationKey = "cloudfoundry.org/garden-properties"
+
+	// CPUAssignmentAnnotationKey holds the number of cores assigned to the
+	// container, which the CPU request of the pod only approximates.
+	CPUAssignmentAnnotationKey = "cloudfoundry.org/cpu-assignment"
+
+	// envSecretKey and propertiesSecretKey are the keys of the state secret
+	// holding the environment and the sensitive properties of the container.
+	envSecretKey        = "env"
+	propertiesSecretKey = "properties"
+
+	// values larger than compressionThreshold are stored gzipped and base64
+	// encoded behind compressedPrefix, to stay within the size limit of the
+	// annotations of a pod.
+	compressionThreshold = 4 * 1024
+	compressedPrefix     = "gzip:"
+)
+
+// sensitiveProperties can hold credentials, like the environment and the image
+// credentials of the executor container, and are not stored in annotations.
+var sensitiveProperties = map[string]bool{
+	ExecutorContainerProperty: true,
+}
+
+// splitProperties separates the sensitive properties from the others.
+func splitProperties(properties garden.Properties) (garden.Properties, garden.Properties) {
+	plain, sensitive := garden.Properties{}, garden.Properties{}
+	for key, value := range properties {
+		if sensitiveProperties[key] {
+			sensitive[key] = value
+		} else {
+			plain[key] = value
+		}
+	}
+
+	return plain, sensitive
+}
+
+// encodeState encodes the value as JSON, compressing it if it is large.
+func encodeState(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if len(data) <= compressionThreshold {
+		return string(data), nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return compressedPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeState is the reverse of encodeState.
+func decodeState(value string, v any) error {
+	data := []byte(value)
+	if compressed, ok := strings.CutPrefix(value, compressedPrefix); ok {
+		gzipped, err := base64.StdEncoding.DecodeString(compressed)
+		if err != nil {
+			return err
+		}
+
+		reader, err := gzip.NewReader(bytes.NewReader(gzipped))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		data, err = io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// stateAnnotations returns the annotations recording the properties and the
+// CPU assignment of the container on its pod.
+func stateAnnotations(properties garden.Properties, cpuAssignment float64) (map[string]string, error) {
+	plain, _ := splitProperties(properties)
+	value, err := encodeState(plain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode properties: %w", err)
+	}
+
+	return map[string]string{
+		PropertiesAnnotationKey:    value,
+		CPUAssignmentAnnotationKey: strconv.FormatFloat(cpuAssignment, 'f', -1, 64),
+	}, nil
+}
+
+func newStateSecret(handle, namespace string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      handle,
+			Namespace: namespace,
+			Labels: map[string]string{
+				HandleLabelKey: handle,
+			},
+		},
+	}
+}
+
+// applyStateSecret stores the environment and the sensitive properties of the
+// container in its state secret, creating the secret if it does not exist yet.
+func applyStateSecret(clnt ctrlclient.Client, d deadlines, handle, namespace string, env []string, properties garden.Properties) error {
+	_, sensitive := splitProperties(properties)
+
+	encodedEnv, err := encodeState(env)
+	if err != nil {
+		return fmt.Errorf("failed to encode environment: %w", err)
+	}
+	encodedProperties, err := encodeState(sensitive)
+	if err != nil {
+		return fmt.Errorf("failed to encode properties: %w", err)
+	}
+
+	ctx, cancel := d.apiContext()
+	defer cancel()
+
+	secret := newStateSecret(handle, namespace)
+	_, err = controllerutil.CreateOrUpdate(ctx, clnt, secret, func() error {
+		secret.Data = map[string][]byte{
+			envSecretKey:        []byte(encodedEnv),
+			propertiesSecretKey: []byte(encodedProperties),
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply state secret: %w", err)
+	}
+
+	return nil
+}
+
+func deleteStateSecret(clnt ctrlclient.Client, d deadlines, handle, namespace string) error {
+	ctx, cancel := d.apiContext()
+	defer cancel()
+
+	return ctrlclient.IgnoreNotFound(clnt.Delete(ctx, newStateSecret(handle, namespace)))
+}
+
+// podState is the state of a container recorded on its pod and state secret.
+type podState struct {
+	properties    garden.Properties
+	env           []string
+	cpuAssignment float64
+	rootfsSize    uint64
+}
+
+// restorePodState reads the state of the container of the pod. Pods created
+// before the state was recorded fall back to what the labels and the spec of
+// the pod tell.
+func restorePodState(clnt ctrlclient.Client, d deadlines, pod *corev1.Pod) (podState, error) {
+	rootfsSize, err := podRootfsSize(pod)
+	if err != nil {
+		return podState{}, err
+	}
+
+	state := podState{
+		properties:    podProperties(pod),
+		env:           []string{},
+		cpuAssignment: podCPUAssignment(pod),
+		rootfsSize:    rootfsSize,
+	}
+
+	if value, ok := pod.Annotations[PropertiesAnnotationKey]; ok {
+		properties := garden.Properties{}
+		if err := decodeState(value, &properties); err != nil {
+			return podState{}, fmt.Errorf("failed to decode annotation %s of pod %s: %w", PropertiesAnnotationKey, pod.Name, err)
+		}
+		state.properties = properties
+	}
+
+	if value, ok := pod.Annotations[CPUAssignmentAnnotationKey]; ok {
+		cpuAssignment, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return podState{}, fmt.Errorf("failed to parse annotation %s of pod %s: %w", CPUAssignmentAnnotationKey, pod.Name, err)
+		}
+		state.cpuAssignment = cpuAssignment
+	}
+
+	ctx, cancel := d.apiContext()
+	defer cancel()
+
+	secret := &corev1.Secret{}
+	if err := clnt.Get(ctx, ctrlclient.ObjectKey{Name: pod.Name, Namespace: pod.Namespace}, secret); err != nil {
+		if ctrlclient.IgnoreNotFound(err) == nil {
+			return state, nil
+		}
+		return podState{}, fmt.Errorf("failed to get state secret of pod %s: %w", pod.Name, err)
+	}
+
+	if value, ok := secret.Data[envSecretKey]; ok {
+		if err := decodeState(string(value), &state.env); err != nil {
+			return podState{}, fmt.Errorf("failed to decode environment of pod %s: %w", pod.Name, err)
+		}
+	}
+
+	if value, ok := secret.Data[propertiesSecretKey]; ok {
+		sensitive := garden.Properties{}
+		if err := decodeState(string(value), &sensitive); err != nil {
+			return podState{}, fmt.Errorf("failed to decode properties of pod %s: %w", pod.Name, err)
+		}
+		maps.Copy(state.properties, sensitive)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,99 @@
+package k8sgarden
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const (
+	defaultPodStartupTimeout   = 2 * time.Minute
+	defaultAPIOperationTimeout = 10 * time.Second
+	defaultPollInterval        = 500 * time.Millisecond
+)
+
+// Timeouts bound the Kubernetes operations of the client.
+type Timeouts struct {
+	// PodStartup is how long Create waits for the pod to be running.
+	PodStartup time.Duration
+	// APIOperation is how long a request to the API server may take,
+	// including waiting for a deleted pod to be gone.
+	APIOperation time.Duration
+	// PollInterval is how often the API server is asked whether a deleted
+	// pod is gone, and how often deleting the pods of destroyed containers is
+	// retried.
+	PollInterval time.Duration
+}
+
+// deadlines derives the contexts of the Kubernetes operations from the
+// clock, so that their timeouts can be driven by a fake clock in tests.
+type deadlines struct {
+	clock    clock.Clock
+	timeouts Timeouts
+}
+
+func newDeadlines(clk clock.Clock, timeouts Timeouts) deadlines {
+	return deadlines{clock: clk, timeouts: timeouts}
+}
+
+// apiContext returns the context of a request to the API server.
+func (d deadlines) apiContext() (context.Context, context.CancelFunc) {
+	return d.withTimeout(context.Background(), d.timeouts.APIOperation)
+}
+
+// podStartupContext returns the context of creating a pod and waiting for it
+// to be running.
+func (d deadlines) podStartupContext() (context.Context, context.CancelFunc) {
+	return d.withTimeout(context.Background(), d.timeouts.PodStartup)
+}
+
+// withTimeout is [context.WithTimeout] on the clock. The context reports
+// [context.DeadlineExceeded] once the timeout has passed.
+func (d deadlines) withTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := d.clock.NewTimer(timeout)
+
+	go func() {
+		select {
+		case <-timer.C():
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+		}
+	}()
+
+	// the timer is stopped right away, so that it no longer counts as a
+	// watcher of a fake clock once the operation is done
+	return clockContext{ctx}, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// poll waits for the poll interval to pass, or returns the error of the
+// context if it is done first.
+func (d deadlines) poll(ctx context.Context) error {
+	timer := d.clock.NewTimer(d.timeouts.PollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// clockContext reports the cause of its cancellation as its error, so that
+// it can not be told apart from a context of [context.WithTimeout].
+type clockContext struct {
+	context.Context
+}
+
+func (c clockContext) Err() error {
+	if c.Context.Err() == nil {
+		return nil
+	}
+
+	return context.Cause(c.Context)
+}